@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+
+	"github.com/conductorone/baton-snyk/pkg/connector"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	cloneSource   = "source"
+	cloneTargets  = "target"
+	cloneConflict = "conflict"
+)
+
+// cloneCommand copies members and their roles from a template organization into target organizations.
+func cloneCommand(ctx context.Context, v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "clone-org",
+		Short: "Copy members and their roles from a source organization into target organizations",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			runCtx, err := initLogger(ctx, v)
+			if err != nil {
+				return err
+			}
+
+			source, err := cmd.Flags().GetString(cloneSource)
+			if err != nil {
+				return err
+			}
+
+			targets, err := cmd.Flags().GetStringSlice(cloneTargets)
+			if err != nil {
+				return err
+			}
+
+			conflict, err := cmd.Flags().GetString(cloneConflict)
+			if err != nil {
+				return err
+			}
+
+			cb, err := newConnector(runCtx, v)
+			if err != nil {
+				return err
+			}
+
+			changes, err := cb.PlanClone(runCtx, source, targets, conflict)
+			if err != nil {
+				return err
+			}
+
+			return applyChanges(runCtx, cmd.OutOrStdout(), cb, changes)
+		},
+	}
+
+	cmd.Flags().String(cloneSource, "", "ID or slug of the organization to copy members from.")
+	cmd.Flags().StringSlice(cloneTargets, nil, "IDs or slugs of the organizations to copy members into.")
+	cmd.Flags().String(
+		cloneConflict,
+		connector.CloneSkip,
+		"What happens to users who are members of a target already: skip (keep their role), overwrite (assign the source role) or higher (assign the source role if it is admin).",
+	)
+	_ = cmd.MarkFlagRequired(cloneSource)
+	_ = cmd.MarkFlagRequired(cloneTargets)
+
+	return cmd
+}
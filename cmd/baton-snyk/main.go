@@ -4,12 +4,15 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	configSchema "github.com/conductorone/baton-sdk/pkg/config"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
 	"github.com/conductorone/baton-sdk/pkg/field"
+	"github.com/conductorone/baton-sdk/pkg/logging"
 	"github.com/conductorone/baton-sdk/pkg/types"
 	"github.com/conductorone/baton-snyk/pkg/connector"
+	"github.com/conductorone/baton-snyk/pkg/snyk"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -22,14 +25,66 @@ const (
 
 var (
 	apiToken            = field.StringField(connector.APIToken, field.WithRequired(true), field.WithDescription("API token representing user or service account, used to authenticate with Snyk API."))
-	groupID             = field.StringField(connector.GroupID, field.WithRequired(true), field.WithDescription("Snyk group ID to scope the synchronization."))
-	organizationIDs     = field.StringField(connector.OrgIDs, field.WithDescription("Limit syncing to specified organizations."))
-	configurationFields = []field.SchemaField{apiToken, groupID, organizationIDs}
+	groupID             = field.StringSliceField(connector.GroupID, field.WithDescription("Snyk group IDs to scope the synchronization. Without a group, organizations accessible with the API token are synced."))
+	tenantID            = field.StringField(connector.TenantID, field.WithDescription("Snyk tenant ID above the groups. When the API token has tenant scope, the tenant is synced with groups nested under it."))
+	organizationIDs     = field.StringSliceField(connector.OrgIDs, field.WithDescription("Limit syncing to organizations with specified IDs or matching ID glob patterns."))
+	organizationSlugs   = field.StringSliceField(connector.OrgSlugs, field.WithDescription("Limit syncing to organizations with specified slugs or matching slug glob patterns."))
+	organizationNames   = field.StringSliceField(connector.OrgNames, field.WithDescription("Limit syncing to organizations with specified names or matching name glob patterns."))
+	organizationInclude = field.StringSliceField(connector.OrgInclude, field.WithDescription("Limit syncing to organizations whose name or slug matches any of the regular expressions."))
+	organizationExclude = field.StringSliceField(connector.OrgExclude, field.WithDescription("Exclude organizations whose name or slug matches any of the regular expressions from syncing."))
+	organizationAttrs   = field.StringSliceField(connector.OrgAttributes, field.WithDescription("Limit syncing to organizations matching all the attribute glob patterns, specified as key=pattern (keys: id, name, slug, url, created, group_id)."))
+	userRules           = field.StringSliceField(connector.UserRules, field.WithDescription("Rules excluding or labeling principals, specified as <action>:<matcher>=<value>, e.g. exclude:email_domain=example.com or label=bot:account_type=service_account."))
+	optimizedSync       = field.BoolField(connector.OptimizedSync, field.WithDescription("Build organization memberships from the group members response instead of listing members of each organization."))
+	prefetchOrgs        = field.BoolField(connector.PrefetchOrgs, field.WithDescription("Fetch members of all organizations concurrently before syncing their grants."))
+	orgFetchConcurrency = field.IntField(connector.OrgFetchConcurrency, field.WithDefaultValue(connector.DefaultOrgFetchConcurrency), field.WithDescription("Maximum number of organizations fetched at once when prefetching organizations."))
+	readOnly            = field.BoolField(connector.ReadOnly, field.WithDescription("Disable provisioning, so the connector never modifies Snyk and only advertises the sync capability."))
+	dryRun              = field.BoolField(connector.DryRun, field.WithDescription("Log the Snyk requests grants and revokes would make instead of sending them."))
+	allowLastAdmin      = field.BoolField(connector.AllowLastAdminRemoval, field.WithDescription("Allow grants and revokes to demote or remove the last admin of an organization or tenant."))
+	protectedPrincipals = field.StringSliceField(connector.ProtectedPrincipals, field.WithDescription("IDs or emails of users that grants and revokes never demote or remove. The identity of the API token is always protected."))
+	orgMemberRole       = field.StringField(connector.OrgMemberRole, field.WithDefaultValue(snyk.OrgCollaboratorRole), field.WithDescription("Role (public ID, slug or name) of users granted organization membership."))
+	orgDemoteRole       = field.StringField(connector.OrgDemoteRole, field.WithDescription("Role (public ID, slug or name) members are demoted to when their organization role is revoked. Defaults to the member role."))
+	tenantMemberRole    = field.StringField(connector.TenantMemberRole, field.WithDefaultValue(connector.DefaultTenantMemberRole), field.WithDescription("Tenant role (ID or name) of users granted tenant membership and members are demoted to when their tenant role is revoked."))
+	tenantAdminRoles    = field.StringSliceField(connector.TenantAdminRoles, field.WithDefaultValue([]string{connector.DefaultTenantAdminRole}), field.WithDescription("Tenant roles (IDs or names) counted as tenant admins by the last admin check."))
+	orgRevokeBehavior   = field.StringField(connector.OrgRevokeBehavior, field.WithDefaultValue(connector.OrgRevokeDemote), field.WithDescription("What revoking an organization role does: demote (to the demote role) or remove (from the organization)."))
+	verifyWrites        = field.BoolField(connector.VerifyWrites, field.WithDescription("After grants and revokes, poll organization and tenant memberships until Snyk reflects the change, failing the operation if it never does."))
+	journalPath         = field.StringField(connector.JournalPath, field.WithDescription("File every membership change is appended to as JSON lines, so it can be reviewed and undone with the undo command."))
+	incrementalState    = field.StringField(connector.IncrementalSyncState, field.WithDescription("File members of synced organizations are saved to, so the next sync only lists members of organizations with changes in the audit logs since."))
+	fullSyncInterval    = field.StringField(connector.FullSyncInterval, field.WithDefaultValue("24h"), field.WithDescription("How often incremental sync lists members of every organization anyway, as a duration."))
+	dormancyThreshold   = field.StringField(connector.DormancyThreshold, field.WithDescription("Read the last activity of users from the audit logs and flag users without activity for this long as dormant, as a duration, e.g. 720h."))
+	configurationFields = []field.SchemaField{
+		apiToken,
+		groupID,
+		tenantID,
+		organizationIDs,
+		organizationSlugs,
+		organizationNames,
+		organizationInclude,
+		organizationExclude,
+		organizationAttrs,
+		userRules,
+		optimizedSync,
+		prefetchOrgs,
+		orgFetchConcurrency,
+		readOnly,
+		dryRun,
+		allowLastAdmin,
+		protectedPrincipals,
+		orgMemberRole,
+		orgDemoteRole,
+		orgRevokeBehavior,
+		tenantMemberRole,
+		tenantAdminRoles,
+		verifyWrites,
+		journalPath,
+		incrementalState,
+		fullSyncInterval,
+		dormancyThreshold,
+	}
 )
 
 func main() {
 	ctx := context.Background()
-	_, cmd, err := configSchema.DefineConfiguration(ctx,
+	v, cmd, err := configSchema.DefineConfiguration(ctx,
 		connectorName,
 		getConnector,
 		field.NewConfiguration(configurationFields),
@@ -40,6 +95,14 @@ func main() {
 	}
 
 	cmd.Version = version
+	cmd.AddCommand(
+		undoCommand(ctx, v),
+		planCommand(ctx, v),
+		applyCommand(ctx, v),
+		cloneCommand(ctx, v),
+		migrateRoleCommand(ctx, v),
+	)
+
 	err = cmd.Execute()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -47,13 +110,98 @@ func main() {
 	}
 }
 
+// initLogger returns the context with the logger configured by the log flags, for commands running the connector directly.
+func initLogger(ctx context.Context, v *viper.Viper) (context.Context, error) {
+	return logging.Init(
+		ctx,
+		logging.WithLogFormat(v.GetString("log-format")),
+		logging.WithLogLevel(v.GetString("log-level")),
+	)
+}
+
+// newConfig returns the connector configuration from the command line, environment and config file.
+func newConfig(cfg *viper.Viper) (*connector.Config, error) {
+	fullSyncInterval, err := parseDuration(cfg, connector.FullSyncInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	dormancyThreshold, err := parseDuration(cfg, connector.DormancyThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connector.Config{
+		GroupIDs:              cfg.GetStringSlice(connector.GroupID),
+		TenantID:              cfg.GetString(connector.TenantID),
+		Token:                 cfg.GetString(connector.APIToken),
+		Orgs:                  cfg.GetStringSlice(connector.OrgIDs),
+		OrgSlugs:              cfg.GetStringSlice(connector.OrgSlugs),
+		OrgNames:              cfg.GetStringSlice(connector.OrgNames),
+		OrgInclude:            cfg.GetStringSlice(connector.OrgInclude),
+		OrgExclude:            cfg.GetStringSlice(connector.OrgExclude),
+		OrgAttributes:         cfg.GetStringSlice(connector.OrgAttributes),
+		UserRules:             cfg.GetStringSlice(connector.UserRules),
+		OptimizedSync:         cfg.GetBool(connector.OptimizedSync),
+		PrefetchOrgs:          cfg.GetBool(connector.PrefetchOrgs),
+		OrgFetchConcurrency:   cfg.GetInt(connector.OrgFetchConcurrency),
+		ReadOnly:              cfg.GetBool(connector.ReadOnly),
+		DryRun:                cfg.GetBool(connector.DryRun),
+		AllowLastAdminRemoval: cfg.GetBool(connector.AllowLastAdminRemoval),
+		ProtectedPrincipals:   cfg.GetStringSlice(connector.ProtectedPrincipals),
+		OrgMemberRole:         cfg.GetString(connector.OrgMemberRole),
+		OrgDemoteRole:         cfg.GetString(connector.OrgDemoteRole),
+		OrgRevokeBehavior:     cfg.GetString(connector.OrgRevokeBehavior),
+		TenantMemberRole:      cfg.GetString(connector.TenantMemberRole),
+		TenantAdminRoles:      cfg.GetStringSlice(connector.TenantAdminRoles),
+		VerifyWrites:          cfg.GetBool(connector.VerifyWrites),
+		JournalPath:           cfg.GetString(connector.JournalPath),
+		IncrementalSyncState:  cfg.GetString(connector.IncrementalSyncState),
+		FullSyncInterval:      fullSyncInterval,
+		DormancyThreshold:     dormancyThreshold,
+	}, nil
+}
+
+// newConnector returns the connector configured from the command line, environment and config file,
+// for commands running it directly.
+func newConnector(ctx context.Context, v *viper.Viper) (*connector.Snyk, error) {
+	config, err := newConfig(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return connector.New(ctx, config)
+}
+
+// parseDuration returns the duration option, failing on values time.ParseDuration rejects, e.g. "1d",
+// instead of reading them as zero. Durations can't be negative.
+func parseDuration(cfg *viper.Viper, name string) (time.Duration, error) {
+	value := cfg.GetString(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --%s %q, expected a duration such as 90m or 24h", name, value)
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("invalid --%s %q, the duration can't be negative", name, value)
+	}
+
+	return d, nil
+}
+
 func getConnector(ctx context.Context, cfg *viper.Viper) (types.ConnectorServer, error) {
 	l := ctxzap.Extract(ctx)
-	cb, err := connector.New(ctx,
-		cfg.GetString(connector.GroupID),
-		cfg.GetString(connector.APIToken),
-		cfg.GetStringSlice(connector.OrgIDs),
-	)
+	config, err := newConfig(cfg)
+	if err != nil {
+		l.Error("error creating connector", zap.Error(err))
+		return nil, err
+	}
+
+	cb, err := connector.New(ctx, config)
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/conductorone/baton-snyk/pkg/connector"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	manifestPath  = "manifest"
+	manifestPrune = "prune"
+)
+
+// planCommand prints the membership changes converging organizations to the manifest.
+func planCommand(ctx context.Context, v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show membership changes converging organizations to the manifest",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			_, _, changes, err := planManifest(ctx, cmd, v)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ACTION\tORG\tUSER\tPRINCIPAL\tPREVIOUS\tNEW\tNOTE")
+			for _, c := range changes {
+				writeChange(w, &c)
+				fmt.Fprintf(w, "%s\n", c.Skipped)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	addManifestFlags(cmd)
+	return cmd
+}
+
+// applyCommand makes the membership changes converging organizations to the manifest.
+func applyCommand(ctx context.Context, v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Converge organization memberships to the manifest",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			runCtx, cb, changes, err := planManifest(ctx, cmd, v)
+			if err != nil {
+				return err
+			}
+
+			return applyChanges(runCtx, cmd.OutOrStdout(), cb, changes)
+		},
+	}
+
+	addManifestFlags(cmd)
+	return cmd
+}
+
+func addManifestFlags(cmd *cobra.Command) {
+	cmd.Flags().String(manifestPath, "", "Path of the YAML or JSON manifest declaring the role of every principal in each organization.")
+	cmd.Flags().Bool(manifestPrune, false, "Remove members of the organizations in the manifest that the manifest doesn't declare.")
+	_ = cmd.MarkFlagRequired(manifestPath)
+}
+
+// planManifest loads the manifest and diffs it against live memberships.
+func planManifest(ctx context.Context, cmd *cobra.Command, v *viper.Viper) (context.Context, *connector.Snyk, []connector.PlannedChange, error) {
+	runCtx, err := initLogger(ctx, v)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	path, err := cmd.Flags().GetString(manifestPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	prune, err := cmd.Flags().GetBool(manifestPrune)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	manifest, err := connector.LoadManifest(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	cb, err := newConnector(runCtx, v)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	changes, err := cb.Plan(runCtx, manifest, prune)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return runCtx, cb, changes, nil
+}
+
+// applyChanges makes the changes and reports the outcome of each, failing if any of them failed.
+func applyChanges(ctx context.Context, out io.Writer, cb *connector.Snyk, changes []connector.PlannedChange) error {
+	results, err := cb.Apply(ctx, changes)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tORG\tUSER\tPRINCIPAL\tPREVIOUS\tNEW\tSTATUS\tDETAIL")
+
+	failed := 0
+	for _, r := range results {
+		writeChange(w, &r.Change)
+		fmt.Fprintf(w, "%s\t%s\n", r.Status, r.Detail)
+
+		if r.Status == connector.ChangeFailed {
+			failed++
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to apply %d of %d changes", failed, len(results))
+	}
+
+	return nil
+}
+
+func writeChange(w io.Writer, c *connector.PlannedChange) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t", c.Action, c.OrgName, c.UserID, c.Principal, c.PreviousRole, c.NewRole)
+}
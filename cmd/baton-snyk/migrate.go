@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/conductorone/baton-snyk/pkg/connector"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	migrateFromRole    = "from-role"
+	migrateToRole      = "to-role"
+	migrateConcurrency = "concurrency"
+	migrateProgress    = "progress-file"
+)
+
+// migrateRoleCommand moves every holder of an org role to another role in all synced organizations.
+func migrateRoleCommand(ctx context.Context, v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate-role",
+		Short: "Move every holder of an organization role to another role in all synced organizations",
+		Long: "Move every holder of an organization role to another role in all synced organizations. " +
+			"Use --dry-run to preview the changes. With --progress-file, completed organizations are recorded, " +
+			"so an interrupted migration resumes where it stopped when run again.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			runCtx, err := initLogger(ctx, v)
+			if err != nil {
+				return err
+			}
+
+			// stop starting new organizations on interrupt, so the progress stays consistent
+			runCtx, stop := signal.NotifyContext(runCtx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			m := connector.RoleMigration{}
+			if m.FromRole, err = cmd.Flags().GetString(migrateFromRole); err != nil {
+				return err
+			}
+
+			if m.ToRole, err = cmd.Flags().GetString(migrateToRole); err != nil {
+				return err
+			}
+
+			if m.Concurrency, err = cmd.Flags().GetInt(migrateConcurrency); err != nil {
+				return err
+			}
+
+			if m.ProgressPath, err = cmd.Flags().GetString(migrateProgress); err != nil {
+				return err
+			}
+
+			cb, err := newConnector(runCtx, v)
+			if err != nil {
+				return err
+			}
+
+			summaries, migrateErr := cb.MigrateRole(runCtx, m)
+			if migrateErr != nil && len(summaries) == 0 {
+				return migrateErr
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "GROUP\tORG\tMIGRATED\tSKIPPED\tFAILED\tNOTE")
+
+			var migrated, skipped, failed int
+			for _, summary := range summaries {
+				note := strings.Join(summary.Errors, "; ")
+				if summary.Resumed {
+					note = "completed by a previous run"
+				}
+
+				fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", summary.GroupID, summary.OrgName, summary.Migrated, summary.Skipped, summary.Failed, note)
+
+				migrated += summary.Migrated
+				skipped += summary.Skipped
+				failed += summary.Failed
+			}
+
+			total := fmt.Sprintf("%d orgs", len(summaries))
+			if cb.DryRun {
+				total += " (dry run)"
+			}
+			fmt.Fprintf(w, "TOTAL\t%s\t%d\t%d\t%d\t\n", total, migrated, skipped, failed)
+
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if migrateErr != nil {
+				return migrateErr
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("failed to migrate %d memberships", failed)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String(migrateFromRole, "", "Public ID of the organization role to migrate members from.")
+	cmd.Flags().String(migrateToRole, "", "Public ID of the organization role to migrate members to.")
+	cmd.Flags().Int(migrateConcurrency, connector.DefaultOrgFetchConcurrency, "Maximum number of organizations migrated at once.")
+	cmd.Flags().String(migrateProgress, "", "File recording organizations completed by the migration, so it can be resumed after an interruption.")
+	_ = cmd.MarkFlagRequired(migrateFromRole)
+	_ = cmd.MarkFlagRequired(migrateToRole)
+
+	return cmd
+}
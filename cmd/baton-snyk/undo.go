@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/connector"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	undoOperationIDs = "operation-id"
+	undoSince        = "since"
+	undoUntil        = "until"
+)
+
+// undoCommand rolls back membership changes recorded in the journal.
+func undoCommand(ctx context.Context, v *viper.Viper) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Undo membership changes recorded in the journal",
+		Long: "Undo membership changes recorded in the journal, selected by operation ID or by the time window they were made in. " +
+			"Changes are undone from the most recent, and skipped if the membership changed since. " +
+			"Rollbacks made by undo are only undone when selected by operation ID.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			runCtx, err := initLogger(ctx, v)
+			if err != nil {
+				return err
+			}
+
+			filter := connector.UndoFilter{}
+			filter.OperationIDs, err = cmd.Flags().GetStringSlice(undoOperationIDs)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			filter.Since, err = parseUndoTime(cmd, undoSince, now)
+			if err != nil {
+				return err
+			}
+
+			filter.Until, err = parseUndoTime(cmd, undoUntil, now)
+			if err != nil {
+				return err
+			}
+
+			cb, err := newConnector(runCtx, v)
+			if err != nil {
+				return err
+			}
+
+			results, err := cb.Undo(runCtx, filter)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "OPERATION\tTIME\tSCOPE\tACTION\tUSER\tRESOURCE\tPREVIOUS\tNEW\tSTATUS\tDETAIL")
+
+			failed := 0
+			for _, r := range results {
+				resource := r.Entry.OrgID
+				if r.Entry.Scope == connector.JournalScopeTenant {
+					resource = r.Entry.TenantID
+				}
+
+				fmt.Fprintf(
+					w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					r.Entry.ID, r.Entry.Time.Format(time.RFC3339), r.Entry.Scope, r.Entry.Action, r.Entry.UserID,
+					resource, r.Entry.PreviousRole, r.Entry.NewRole, r.Status, r.Detail,
+				)
+
+				if r.Status == connector.UndoFailed {
+					failed++
+				}
+			}
+
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("failed to undo %d of %d changes", failed, len(results))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSlice(undoOperationIDs, nil, "IDs of the journal entries to undo.")
+	cmd.Flags().String(undoSince, "", "Undo changes made at or after this time, as RFC 3339 or a duration before now, e.g. 2h.")
+	cmd.Flags().String(undoUntil, "", "Undo changes made at or before this time, as RFC 3339 or a duration before now. Defaults to now.")
+	cmd.MarkFlagsOneRequired(undoOperationIDs, undoSince)
+	cmd.MarkFlagsMutuallyExclusive(undoOperationIDs, undoSince)
+	cmd.MarkFlagsMutuallyExclusive(undoOperationIDs, undoUntil)
+
+	return cmd
+}
+
+// parseUndoTime parses the flag either as RFC 3339 time, or as a duration before now.
+func parseUndoTime(cmd *cobra.Command, name string, now time.Time) (time.Time, error) {
+	value, err := cmd.Flags().GetString(name)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --%s %q, expected RFC 3339 time or a duration", name, value)
+	}
+
+	return now.Add(-d), nil
+}
@@ -0,0 +1,127 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// activityRefresh is how long the activity read from the audit logs is reused, so a single sync reads it once.
+const activityRefresh = time.Hour
+
+// userActivity describes when the user was last active.
+type userActivity struct {
+	// Last is the time of the latest audit log event made by the user, zero when there is none in the window.
+	Last    time.Time
+	Dormant bool
+}
+
+// activityIndex holds the latest audit log event made by each user within the dormancy threshold.
+// Snyk doesn't report logins of users, so any change or login recorded in the audit logs counts as activity.
+type activityIndex struct {
+	threshold time.Duration
+	scopes    func(ctx context.Context) ([]auditLogScope, error)
+
+	mtx    sync.Mutex
+	loaded time.Time
+	last   map[string]time.Time
+}
+
+func newActivityIndex(threshold time.Duration, scopes func(ctx context.Context) ([]auditLogScope, error)) *activityIndex {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &activityIndex{
+		threshold: threshold,
+		scopes:    scopes,
+	}
+}
+
+// Refresh reads the audit logs when the activity wasn't read within the refresh interval. It is called before
+// users are streamed. Failing to read them doesn't fail the sync, users are listed without their activity.
+func (a *activityIndex) Refresh(ctx context.Context) {
+	if a == nil {
+		return
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if time.Since(a.loaded) <= activityRefresh {
+		return
+	}
+
+	if err := a.load(ctx); err != nil {
+		ctxzap.Extract(ctx).Warn("snyk-connector: failed to read user activity, listing users without it", zap.Error(err))
+		// the rest of the sync doesn't retry, so users of every group aren't each delayed by the failing search
+		a.last = nil
+		a.loaded = time.Now()
+	}
+}
+
+// Lookup returns the activity of the user, or nil when activity isn't tracked or couldn't be read.
+func (a *activityIndex) Lookup(userID string) *userActivity {
+	if a == nil {
+		return nil
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.last == nil {
+		return nil
+	}
+
+	last, ok := a.last[userID]
+	return &userActivity{Last: last, Dormant: !ok}
+}
+
+// load reads the audit logs of the dormancy window, from the oldest event.
+func (a *activityIndex) load(ctx context.Context) error {
+	start := time.Now()
+
+	scopes, err := a.scopes(ctx)
+	if err != nil {
+		return err
+	}
+
+	last := make(map[string]time.Time)
+	// the search can't reach past the retention of the audit logs
+	query := &snyk.AuditLogQuery{From: start.Add(-min(a.threshold, auditLogRetention))}
+	for _, scope := range scopes {
+		next := ""
+		for {
+			items, link, err := scope.search(ctx, query, next)
+			if err != nil {
+				return fmt.Errorf("snyk-connector: failed to search audit logs of %s: %w", scope.key, err)
+			}
+
+			for _, item := range items {
+				if item.UserID != "" && item.Created.After(last[item.UserID]) {
+					last[item.UserID] = item.Created
+				}
+			}
+
+			if link == "" {
+				break
+			}
+			next = link
+		}
+	}
+
+	ctxzap.Extract(ctx).Info(
+		"snyk-connector: read user activity from audit logs",
+		zap.Time("since", query.From),
+		zap.Int("active_users", len(last)),
+	)
+
+	a.last = last
+	a.loaded = start
+	return nil
+}
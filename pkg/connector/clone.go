@@ -0,0 +1,137 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+)
+
+const (
+	// CloneSkip leaves members of the target organization unchanged.
+	CloneSkip = "skip"
+	// CloneOverwrite assigns members of the target organization the role they have in the source organization.
+	CloneOverwrite = "overwrite"
+	// CloneHigher assigns the role of the source organization only when it is higher. Only admin is known to be
+	// higher than other roles, custom roles can't be compared, so members keep them.
+	CloneHigher = "higher"
+)
+
+// PlanClone returns the changes copying every explicit member of the source organization and their role into the
+// target organizations. The conflict strategy decides what happens to users who are members of a target already.
+// Members of the targets who aren't members of the source are kept.
+func (s *Snyk) PlanClone(ctx context.Context, source string, targets []string, conflict string) ([]PlannedChange, error) {
+	switch conflict {
+	case CloneSkip, CloneOverwrite, CloneHigher:
+	default:
+		return nil, fmt.Errorf("snyk-connector: invalid conflict strategy '%s', expected %s, %s or %s", conflict, CloneSkip, CloneOverwrite, CloneHigher)
+	}
+
+	orgs := newOrgBuilder(s.groups, s.OrgFilter, nil, s.policy, s.verifier, s.journal, nil, false, false, 0)
+
+	resolved, err := s.resolveOrgs(ctx, orgs, append([]string{source}, targets...))
+	if err != nil {
+		return nil, err
+	}
+
+	src := resolved[source]
+	srcRoles, err := src.group.lookupRoles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to list roles in org %s: %w", src.org.ID, err)
+	}
+
+	// admins of the source group are members of its organizations only through the group, so they aren't copied
+	srcMembers, err := src.group.client.ListExplicitUsersInOrg(ctx, src.org.ID)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to list users in org %s: %w", src.org.ID, err)
+	}
+
+	var rv []PlannedChange
+	seen := make(map[string]struct{})
+	for _, key := range targets {
+		target := resolved[key]
+		if target.org.ID == src.org.ID {
+			continue
+		}
+
+		if _, ok := seen[target.org.ID]; ok {
+			continue
+		}
+		seen[target.org.ID] = struct{}{}
+
+		// roles are defined per group, so they are matched by their slug
+		roles := srcRoles
+		if target.group != src.group {
+			roles, err = target.group.lookupRoles(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("snyk-connector: failed to list roles in org %s: %w", target.org.ID, err)
+			}
+		}
+
+		members, implicit, err := listOrgMembers(ctx, target.group.client, target.org.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range srcMembers {
+			srcMember := &srcMembers[i]
+
+			change := PlannedChange{
+				GroupID:   target.group.client.GroupID(),
+				OrgID:     target.org.ID,
+				OrgName:   target.org.Name,
+				UserID:    srcMember.ID,
+				Principal: srcMember.Email,
+				NewRole:   srcMember.Role,
+				group:     target.group,
+			}
+
+			role, ok := findOrgRole(roles, srcMember.Role)
+			if ok {
+				change.role = role
+			} else {
+				change.Skipped = fmt.Sprintf("role %s not found in the group of the target", srcMember.Role)
+			}
+
+			j := slices.IndexFunc(members, func(m snyk.OrgUser) bool {
+				return m.ID == srcMember.ID
+			})
+			if j == -1 {
+				change.Action = JournalAdd
+				if change.Skipped == "" && target.group.orgToken {
+					change.Skipped = fmt.Sprintf("adding users to organizations requires %s to be configured", GroupID)
+				}
+
+				rv = append(rv, change)
+				continue
+			}
+
+			member := &members[j]
+			if member.Role == srcMember.Role {
+				continue
+			}
+
+			change.Action = JournalUpdate
+			change.PreviousRole = member.Role
+
+			_, isImplicit := implicit[member.ID]
+			switch {
+			case change.Skipped != "":
+			case isImplicit:
+				change.Skipped = implicitAdminReason
+			case conflict == CloneSkip:
+				change.Skipped = fmt.Sprintf("member already, conflict strategy is %s", CloneSkip)
+			case conflict == CloneHigher && srcMember.Role != snyk.OrgAdminRole:
+				change.Skipped = fmt.Sprintf("the %s role isn't higher", srcMember.Role)
+			default:
+				change.Skipped = s.protectedReason(ctx, member, change.NewRole)
+			}
+
+			rv = append(rv, change)
+		}
+	}
+
+	sortChanges(rv)
+	return rv, nil
+}
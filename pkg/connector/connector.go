@@ -4,32 +4,232 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"slices"
+	"time"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
 	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 type Snyk struct {
-	client  *snyk.Client
-	GroupID string
-	Orgs    []string
+	client              *snyk.Client
+	groups              *groupClients
+	policy              *provisioningPolicy
+	verifier            *writeVerifier
+	journal             *provisioningJournal
+	snapshot            *syncSnapshot
+	activity            *activityIndex
+	eventPrincipals     *principalIndex
+	tenant              *tenantScope
+	TenantID            string
+	GroupIDs            []string
+	OrgFilter           *OrgFilter
+	UserRules           []*UserRule
+	OptimizedSync       bool
+	PrefetchOrgs        bool
+	OrgFetchConcurrency int
+	ReadOnly            bool
+	DryRun              bool
 }
 
 const (
-	APIToken = "api-token"
-	GroupID  = "group-id"
-	OrgIDs   = "org-ids"
+	APIToken              = "api-token"
+	GroupID               = "group-id"
+	TenantID              = "tenant-id"
+	OrgIDs                = "org-ids"
+	OrgSlugs              = "org-slugs"
+	OrgNames              = "org-names"
+	OrgInclude            = "org-include-regex"
+	OrgExclude            = "org-exclude-regex"
+	OrgAttributes         = "org-attributes"
+	UserRules             = "user-rules"
+	OptimizedSync         = "optimized-sync"
+	PrefetchOrgs          = "prefetch-orgs"
+	OrgFetchConcurrency   = "org-fetch-concurrency"
+	ReadOnly              = "read-only"
+	DryRun                = "dry-run"
+	AllowLastAdminRemoval = "allow-last-admin-removal"
+	ProtectedPrincipals   = "protected-principals"
+	OrgMemberRole         = "org-member-role"
+	OrgDemoteRole         = "org-demote-role"
+	TenantMemberRole      = "tenant-member-role"
+	TenantAdminRoles      = "tenant-admin-roles"
+	OrgRevokeBehavior     = "org-revoke-behavior"
+	VerifyWrites          = "verify-writes"
+	JournalPath           = "journal-path"
+	IncrementalSyncState  = "incremental-sync-state"
+	FullSyncInterval      = "full-sync-interval"
+	DormancyThreshold     = "dormancy-threshold"
+
+	OrgRevokeDemote = "demote"
+	OrgRevokeRemove = "remove"
 )
 
+// Config holds the connector configuration.
+type Config struct {
+	// GroupIDs lists the groups to sync, each synced as a separate group resource.
+	GroupIDs []string
+	// TenantID is the tenant above the groups, synced with its members when the token has tenant scope.
+	TenantID string
+	Token    string
+	// Orgs, OrgSlugs and OrgNames limit the sync to organizations matching any of the glob patterns.
+	Orgs     []string
+	OrgSlugs []string
+	OrgNames []string
+	// OrgInclude and OrgExclude are regular expressions matched against the org name and slug.
+	OrgInclude []string
+	OrgExclude []string
+	// OrgAttributes limits the sync to organizations matching all the key=pattern pairs.
+	OrgAttributes []string
+	// UserRules exclude or label principals, see UserRule for the format.
+	UserRules []string
+	// OptimizedSync builds org memberships from the group members response.
+	OptimizedSync bool
+	// PrefetchOrgs fetches members of all organizations concurrently before serving their grants.
+	PrefetchOrgs bool
+	// OrgFetchConcurrency limits the number of organizations fetched at once when prefetching.
+	OrgFetchConcurrency int
+	// ReadOnly disables provisioning, so the connector never mutates Snyk.
+	ReadOnly bool
+	// DryRun resolves provisioning requests and logs the Snyk calls they would make instead of sending them.
+	DryRun bool
+	// AllowLastAdminRemoval disables the check refusing to demote or remove the last admin of an org or tenant.
+	AllowLastAdminRemoval bool
+	// ProtectedPrincipals lists IDs or emails of users that grants and revokes never demote or remove.
+	// The identity of the token is always protected.
+	ProtectedPrincipals []string
+	// OrgMemberRole is the role (public ID, slug or name) of users granted org membership, collaborator by default.
+	OrgMemberRole string
+	// OrgDemoteRole is the role members are demoted to when their role is revoked, OrgMemberRole by default.
+	OrgDemoteRole string
+	// TenantMemberRole is the tenant role (ID or name) of users granted tenant membership and members are demoted to
+	// when their role is revoked, DefaultTenantMemberRole by default.
+	TenantMemberRole string
+	// TenantAdminRoles are the tenant roles (IDs or names) counted as tenant admins, DefaultTenantAdminRole by default.
+	TenantAdminRoles []string
+	// OrgRevokeBehavior is either "demote" (default) or "remove", removing members from the org on any role revoke.
+	OrgRevokeBehavior string
+	// VerifyWrites polls org and tenant memberships after grants and revokes until the change is observed.
+	VerifyWrites bool
+	// JournalPath is the file every membership change is appended to, so it can be undone. Empty disables the journal.
+	JournalPath string
+	// IncrementalSyncState is the file the members of synced organizations are saved to, so the next sync only lists
+	// members of organizations with changes in the audit logs since. Empty disables incremental sync.
+	IncrementalSyncState string
+	// FullSyncInterval is how often incremental sync lists members of every organization anyway.
+	FullSyncInterval time.Duration
+	// DormancyThreshold enables reading the last activity of users from the audit logs. Users without activity
+	// for this long are flagged dormant. Zero disables it.
+	DormancyThreshold time.Duration
+}
+
+// readOnlySyncer exposes only the syncing methods of the builder,
+// so the connector builder doesn't register it as a provisioner.
+type readOnlySyncer struct {
+	connectorbuilder.ResourceSyncer
+}
+
+type provisioningSyncer interface {
+	connectorbuilder.ResourceSyncer
+	connectorbuilder.ResourceProvisioner
+}
+
+type provisioningSyncerV2 interface {
+	connectorbuilder.ResourceSyncer
+	connectorbuilder.ResourceProvisionerV2
+}
+
+// dryRunSyncer and dryRunSyncerV2 annotate grants and revokes of the builder in dry run, so callers can tell
+// the requests changing Snyk were only logged and nothing was changed.
+type dryRunSyncer struct {
+	provisioningSyncer
+}
+
+func (d *dryRunSyncer) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	annos, err := d.provisioningSyncer.Grant(ctx, principal, entitlement)
+	if err != nil {
+		return annos, err
+	}
+
+	return withDryRun(annos), nil
+}
+
+func (d *dryRunSyncer) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	annos, err := d.provisioningSyncer.Revoke(ctx, grant)
+	if err != nil {
+		return annos, err
+	}
+
+	return withDryRun(annos), nil
+}
+
+type dryRunSyncerV2 struct {
+	provisioningSyncerV2
+}
+
+func (d *dryRunSyncerV2) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	grants, annos, err := d.provisioningSyncerV2.Grant(ctx, principal, entitlement)
+	if err != nil {
+		return grants, annos, err
+	}
+
+	return grants, withDryRun(annos), nil
+}
+
+func (d *dryRunSyncerV2) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	annos, err := d.provisioningSyncerV2.Revoke(ctx, grant)
+	if err != nil {
+		return annos, err
+	}
+
+	return withDryRun(annos), nil
+}
+
+// withDryRun adds the dry run marker, {"dry_run": true}, to the annotations.
+func withDryRun(annos annotations.Annotations) annotations.Annotations {
+	annos.Update(&structpb.Struct{Fields: map[string]*structpb.Value{"dry_run": structpb.NewBoolValue(true)}})
+	return annos
+}
+
 // ResourceSyncers returns a ResourceSyncer for each resource type that should be synced from the upstream service.
 func (s *Snyk) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
-	return []connectorbuilder.ResourceSyncer{
-		newGroupBuilder(s.client, s.GroupID),
-		newOrgBuilder(s.client, s.Orgs),
-		newUserBuilder(s.client),
+	principals := newPrincipalIndex(s.groups, s.tenant, s.UserRules, s.OrgFilter)
+
+	syncers := []connectorbuilder.ResourceSyncer{
+		newOrgBuilder(s.groups, s.OrgFilter, principals, s.policy, s.verifier, s.journal, s.snapshot, s.OptimizedSync, s.PrefetchOrgs, s.OrgFetchConcurrency),
+		newUserBuilder(s.groups, s.tenant, s.OrgFilter, s.UserRules, principals, s.activity),
 	}
+
+	// there is no group to sync in org-token mode
+	if !s.groups.OrgTokenMode() {
+		syncers = append([]connectorbuilder.ResourceSyncer{newGroupBuilder(s.groups, s.tenant, principals)}, syncers...)
+	}
+
+	if s.tenant != nil {
+		syncers = append([]connectorbuilder.ResourceSyncer{newTenantBuilder(s.tenant, principals, s.policy)}, syncers...)
+	}
+
+	if s.ReadOnly {
+		for i, syncer := range syncers {
+			syncers[i] = &readOnlySyncer{syncer}
+		}
+	} else if s.DryRun {
+		for i, syncer := range syncers {
+			switch p := syncer.(type) {
+			case provisioningSyncerV2:
+				syncers[i] = &dryRunSyncerV2{p}
+			case provisioningSyncer:
+				syncers[i] = &dryRunSyncer{p}
+			}
+		}
+	}
+
+	return syncers
 }
 
 // Asset takes an input AssetRef and attempts to fetch it using the connector's authenticated http client
@@ -42,30 +242,274 @@ func (s *Snyk) Asset(ctx context.Context, asset *v2.AssetRef) (string, io.ReadCl
 func (s *Snyk) Metadata(ctx context.Context) (*v2.ConnectorMetadata, error) {
 	return &v2.ConnectorMetadata{
 		DisplayName: "Snyk",
-		Description: "Connector syncing Snyk parent group and its organizations and users to Baton",
+		Description: "Connector syncing Snyk tenants, groups and their organizations and users to Baton",
 	}, nil
 }
 
 // Validate is called to ensure that the connector is properly configured. It should exercise any API credentials
 // to be sure that they are valid.
 func (s *Snyk) Validate(ctx context.Context) (annotations.Annotations, error) {
-	_, err := s.client.GetGroupDetails(ctx)
+	if s.groups.OrgTokenMode() {
+		orgs, err := s.groups.orgClient.ListCurrentUserOrgs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to validate credentials: %w", err)
+		}
+
+		if len(orgs) == 0 {
+			return nil, fmt.Errorf("snyk-connector: the token doesn't have access to any organization")
+		}
+
+		l := ctxzap.Extract(ctx)
+		for _, rule := range s.OrgFilter.Unmatched(orgs) {
+			l.Warn("snyk-connector: org filter doesn't match any organization", zap.String("filter", rule))
+		}
+
+		annos, err := s.validatePrivileges(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return annos, s.validateOrgRoles(ctx)
+	}
+
+	l := ctxzap.Extract(ctx)
+
+	// tenant scope is optional, groups are synced at the top level without it
+	tenant, err := s.tenant.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to validate credentials for tenant %s: %w", s.TenantID, err)
+	}
+
+	if tenant != nil {
+		l.Info("snyk-connector: syncing groups under the tenant", zap.String("tenant_id", tenant.ID), zap.String("tenant", tenant.Name))
+	}
+
+	var orgs []snyk.Org
+	for _, groupID := range s.groups.ids {
+		client := s.groups.clients[groupID]
+
+		_, err := client.GetGroupDetails(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to validate credentials for group %s: %w", groupID, err)
+		}
+
+		if s.OrgFilter.IsEmpty() {
+			continue
+		}
+
+		groupOrgs, err := listAllOrgs(ctx, client)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to list orgs in group %s: %w", groupID, err)
+		}
+
+		orgs = append(orgs, groupOrgs...)
+	}
+
+	for _, rule := range s.OrgFilter.Unmatched(orgs) {
+		l.Warn("snyk-connector: org filter doesn't match any organization", zap.String("filter", rule))
+	}
+
+	annos, err := s.validatePrivileges(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("snyk-connector: failed to validate credentials for group %s: %w", s.GroupID, err)
+		return nil, err
 	}
 
-	return nil, nil
+	if err := s.validateTenantRoles(ctx, tenant); err != nil {
+		return nil, err
+	}
+
+	return annos, s.validateOrgRoles(ctx)
+}
+
+// validatePrivileges reports capabilities of the token in the returned annotations and fails when it can't sync,
+// listing every check in the error. Missing permissions to manage memberships are only reported, since they are
+// needed for provisioning only.
+func (s *Snyk) validatePrivileges(ctx context.Context) (annotations.Annotations, error) {
+	report, err := probePrivileges(ctx, s.groups, s.tenant, s.OrgFilter, s.policy)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to detect token privileges: %w", err)
+	}
+
+	report.Log(ctx)
+
+	if missing := report.Missing(CapabilityIdentity, CapabilityListMembers, CapabilityListRoles); len(missing) > 0 {
+		return nil, fmt.Errorf("snyk-connector: the token is missing permissions required for sync: %s (privileges: %s)", missing, report)
+	}
+
+	return report.Annotations()
+}
+
+// validateOrgRoles checks the org roles configured for grants and revokes exist in every synced group.
+func (s *Snyk) validateOrgRoles(ctx context.Context) error {
+	if s.ReadOnly {
+		return nil
+	}
+
+	if s.groups.OrgTokenMode() {
+		if err := s.policy.validateOrgRoles(orgTokenRoles); err != nil {
+			return fmt.Errorf("%w (only built-in roles are available without a group)", err)
+		}
+
+		return nil
+	}
+
+	for _, groupID := range s.groups.ids {
+		roles, err := s.groups.clients[groupID].ListOrgRoles(ctx)
+		if err != nil {
+			return fmt.Errorf("snyk-connector: failed to list roles in group %s: %w", groupID, err)
+		}
+
+		if err := s.policy.validateOrgRoles(roles); err != nil {
+			return fmt.Errorf("%w in group %s", err, groupID)
+		}
+	}
+
+	return nil
+}
+
+// validateTenantRoles checks the tenant roles configured for grants, revokes and the last admin check exist.
+func (s *Snyk) validateTenantRoles(ctx context.Context, tenant *snyk.Tenant) error {
+	if s.ReadOnly || tenant == nil {
+		return nil
+	}
+
+	roles, err := s.tenant.client.ListTenantRoles(ctx, tenant.ID)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to list roles in tenant %s: %w", tenant.ID, err)
+	}
+
+	if err := s.policy.validateTenantRoles(roles); err != nil {
+		return fmt.Errorf("%w in tenant %s", err, tenant.ID)
+	}
+
+	return nil
 }
 
 // New returns a new instance of the connector.
-func New(ctx context.Context, groupID, token string, orgs []string) (*Snyk, error) {
-	client, err := snyk.NewClient(ctx, groupID, token)
+func New(ctx context.Context, cfg *Config) (*Snyk, error) {
+	if cfg.PrefetchOrgs && cfg.OrgFetchConcurrency < 1 {
+		return nil, fmt.Errorf("snyk-connector: %s must be at least 1, got %d", OrgFetchConcurrency, cfg.OrgFetchConcurrency)
+	}
+
+	if cfg.FullSyncInterval < 0 {
+		return nil, fmt.Errorf("snyk-connector: %s can't be negative, got %s", FullSyncInterval, cfg.FullSyncInterval)
+	}
+
+	if cfg.DormancyThreshold < 0 {
+		return nil, fmt.Errorf("snyk-connector: %s can't be negative, got %s", DormancyThreshold, cfg.DormancyThreshold)
+	}
+
+	// audit logs older than the retention are gone, so users are flagged dormant after the retention at the latest
+	if cfg.DormancyThreshold > auditLogRetention {
+		ctxzap.Extract(ctx).Warn(
+			"snyk-connector: dormancy threshold is longer than the audit log retention, users without activity within the retention are flagged dormant",
+			zap.Duration("dormancy_threshold", cfg.DormancyThreshold),
+			zap.Duration("audit_log_retention", auditLogRetention),
+		)
+	}
+
+	orgFilter, err := NewOrgFilter(cfg.Orgs, cfg.OrgSlugs, cfg.OrgNames, cfg.OrgInclude, cfg.OrgExclude, cfg.OrgAttributes)
 	if err != nil {
 		return nil, err
 	}
-	return &Snyk{
-		client:  client,
-		GroupID: groupID,
-		Orgs:    orgs,
-	}, nil
+
+	var userRules []*UserRule
+	for _, rule := range cfg.UserRules {
+		r, err := ParseUserRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		userRules = append(userRules, r)
+	}
+
+	var groupIDs []string
+	for _, groupID := range cfg.GroupIDs {
+		if groupID != "" && !slices.Contains(groupIDs, groupID) {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+
+	client, err := snyk.NewClient(ctx, "", cfg.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := newProvisioningPolicy(client, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var verifier *writeVerifier
+	if cfg.DryRun {
+		ctxzap.Extract(ctx).Info("snyk-connector: dry run enabled, provisioning requests are logged instead of sent")
+		client.SetDryRun(true)
+
+		if cfg.VerifyWrites {
+			ctxzap.Extract(ctx).Warn("snyk-connector: nothing is written in dry run, ignoring " + VerifyWrites)
+		}
+	} else if cfg.VerifyWrites {
+		verifier = newWriteVerifier()
+	}
+
+	s := &Snyk{
+		TenantID:            cfg.TenantID,
+		GroupIDs:            groupIDs,
+		OrgFilter:           orgFilter,
+		UserRules:           userRules,
+		OptimizedSync:       cfg.OptimizedSync,
+		PrefetchOrgs:        cfg.PrefetchOrgs,
+		OrgFetchConcurrency: cfg.OrgFetchConcurrency,
+		ReadOnly:            cfg.ReadOnly,
+		DryRun:              cfg.DryRun,
+		client:              client,
+		policy:              policy,
+		verifier:            verifier,
+		journal:             newProvisioningJournal(cfg.JournalPath, cfg.DryRun),
+	}
+	s.activity = newActivityIndex(cfg.DormancyThreshold, s.auditLogScopes)
+
+	if len(groupIDs) > 0 {
+		s.groups = newGroupClients(client, groupIDs)
+		s.snapshot = newSyncSnapshot(cfg.IncrementalSyncState, cfg.FullSyncInterval, s.groups, orgFilter)
+		if cfg.TenantID != "" {
+			s.tenant = newTenantScope(client, cfg.TenantID, s.verifier, s.journal)
+		}
+		s.eventPrincipals = newPrincipalIndex(s.groups, s.tenant, userRules, orgFilter)
+
+		return s, nil
+	}
+
+	// without a group, sync organizations accessible with the token and disable features relying on group access
+	l := ctxzap.Extract(ctx)
+	l.Info("snyk-connector: no group configured, syncing organizations accessible with the token")
+
+	if s.OptimizedSync || s.PrefetchOrgs {
+		l.Warn(
+			"snyk-connector: group members and roles are not available without a group, ignoring sync options",
+			zap.Bool(OptimizedSync, s.OptimizedSync),
+			zap.Bool(PrefetchOrgs, s.PrefetchOrgs),
+		)
+
+		s.OptimizedSync = false
+		s.PrefetchOrgs = false
+	}
+
+	if cfg.IncrementalSyncState != "" {
+		l.Warn("snyk-connector: incremental sync requires a group, ignoring " + IncrementalSyncState)
+	}
+
+	if cfg.TenantID != "" {
+		return nil, fmt.Errorf("snyk-connector: %s requires %s to be configured", TenantID, GroupID)
+	}
+
+	for _, rule := range userRules {
+		if rule.Matcher == UserMatchGroupRole {
+			return nil, fmt.Errorf("snyk-connector: user rules matching %s require %s to be configured", UserMatchGroupRole, GroupID)
+		}
+	}
+
+	s.groups = newOrgTokenClients(client)
+	s.eventPrincipals = newPrincipalIndex(s.groups, nil, userRules, orgFilter)
+	return s, nil
 }
@@ -0,0 +1,100 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestDryRunProvisioning(t *testing.T) {
+	ctx := context.Background()
+
+	f := newFakeSnyk(t)
+	f.orgs["org-1"] = []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "admin-2", RoleID: "role-admin"},
+		{ID: "user-1", RoleID: "role-collaborator"},
+	}
+
+	s := f.connector(&Config{DryRun: true})
+
+	var orgs connectorbuilder.ResourceProvisionerV2
+	for _, syncer := range s.ResourceSyncers(ctx) {
+		if syncer.ResourceType(ctx).Id == orgResourceType.Id {
+			orgs, _ = syncer.(connectorbuilder.ResourceProvisionerV2)
+		}
+	}
+
+	if orgs == nil {
+		t.Fatal("the org builder isn't a provisioner in dry run")
+	}
+
+	tests := []struct {
+		name  string
+		apply func() (annotations.Annotations, error)
+	}{
+		{
+			name: "grant membership",
+			apply: func() (annotations.Annotations, error) {
+				g := testOrgGrant("org-1", OrgMemberEntitlement, "user-2")
+				_, annos, err := orgs.Grant(ctx, g.Principal, g.Entitlement)
+				return annos, err
+			},
+		},
+		{
+			name: "grant role",
+			apply: func() (annotations.Annotations, error) {
+				g := testOrgGrant("org-1", "role-admin", "user-1")
+				_, annos, err := orgs.Grant(ctx, g.Principal, g.Entitlement)
+				return annos, err
+			},
+		},
+		{
+			name: "revoke role",
+			apply: func() (annotations.Annotations, error) {
+				return orgs.Revoke(ctx, testOrgGrant("org-1", "role-admin", "admin-1"))
+			},
+		},
+		{
+			name: "revoke membership",
+			apply: func() (annotations.Annotations, error) {
+				return orgs.Revoke(ctx, testOrgGrant("org-1", OrgMemberEntitlement, "user-1"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annos, err := tt.apply()
+			if err != nil {
+				t.Fatalf("failed: %v", err)
+			}
+
+			marker := &structpb.Struct{}
+			ok, err := annos.Pick(marker)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !ok || !marker.GetFields()["dry_run"].GetBoolValue() {
+				t.Errorf("annotations %v don't mark the dry run", annos)
+			}
+		})
+	}
+
+	if writes := f.Writes(); len(writes) > 0 {
+		t.Errorf("dry run sent %v, want no changes", writes)
+	}
+
+	entries, err := s.journal.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) > 0 {
+		t.Errorf("dry run journaled %+v, want nothing", entries)
+	}
+}
@@ -0,0 +1,501 @@
+package connector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// defaultEventLookback is how far back the event feed starts when the caller doesn't say.
+	defaultEventLookback = 24 * time.Hour
+	// eventPrincipalsRefresh is how long principals excluded by the user rules are reused by the event feed.
+	eventPrincipalsRefresh = time.Hour
+)
+
+// Audit log events translated into access changes.
+const (
+	auditOrgUserAdd          = "org.user.add"
+	auditOrgUserInviteAccept = "org.user.invite.accept"
+	auditOrgUserRemove       = "org.user.remove"
+	auditOrgUserLeave        = "org.user.leave"
+	auditOrgUserRoleEdit     = "org.user.role.edit"
+	auditGroupUserAdd        = "group.user.add"
+	auditGroupUserRemove     = "group.user.remove"
+	auditGroupUserRoleEdit   = "group.user.role.edit"
+
+	// auditUserField is the content field holding the public ID of the user the change was made to.
+	auditUserField = "userPublicId"
+)
+
+// eventCursor is the state of the event stream, holding a position in the audit log of every searched scope.
+type eventCursor struct {
+	Scopes map[string]*auditLogPosition `json:"scopes"`
+}
+
+// auditLogPosition is where the next audit log search of a scope starts.
+type auditLogPosition struct {
+	From time.Time `json:"from"`
+	// Next is the link to the next page of the current search.
+	Next string `json:"next,omitempty"`
+	// Seen lists events created at From that were returned already, since searches include events created at From.
+	Seen []string `json:"seen,omitempty"`
+}
+
+// advance moves the position past the event and returns its ID. Events before the position, or at it and
+// returned already, were read by a previous page, which searches overlapping at From may return again.
+func (p *auditLogPosition) advance(item *snyk.AuditLogEvent) (string, bool) {
+	id := auditEventID(item)
+	if item.Created.Before(p.From) || slices.Contains(p.Seen, id) {
+		return "", false
+	}
+
+	if item.Created.After(p.From) {
+		p.From = item.Created
+		p.Seen = nil
+	}
+	p.Seen = append(p.Seen, id)
+
+	return id, true
+}
+
+// auditLogScope is a group or an organization whose audit log is searched.
+type auditLogScope struct {
+	key     string
+	groupID string
+	search  func(ctx context.Context, query *snyk.AuditLogQuery, next string) ([]snyk.AuditLogEvent, string, error)
+}
+
+// eventFeed translates audit log events, caching roles and organizations of groups for a single listing.
+type eventFeed struct {
+	groups     *groupClients
+	filter     *OrgFilter
+	principals *principalIndex
+	roles      map[string][]snyk.Role
+	orgs       map[string]map[string]*snyk.Org
+}
+
+// ListEvents returns membership, role and login events from the audit logs of the synced groups, or of the
+// organizations accessible with the token in org-token mode. A page of every audit log is read per call.
+func (s *Snyk) ListEvents(
+	ctx context.Context,
+	earliestEvent *timestamppb.Timestamp,
+	pToken *pagination.StreamToken,
+) ([]*v2.Event, *pagination.StreamState, annotations.Annotations, error) {
+	cursor := &eventCursor{}
+	if pToken != nil && pToken.Cursor != "" {
+		if err := json.Unmarshal([]byte(pToken.Cursor), cursor); err != nil {
+			return nil, nil, nil, fmt.Errorf("snyk-connector: invalid event cursor: %w", err)
+		}
+	}
+	if cursor.Scopes == nil {
+		cursor.Scopes = make(map[string]*auditLogPosition)
+	}
+
+	start := time.Now().Add(-defaultEventLookback)
+	if earliestEvent != nil {
+		start = earliestEvent.AsTime()
+	}
+
+	size := 0
+	if pToken != nil {
+		size = pToken.Size
+	}
+
+	scopes, err := s.auditLogScopes(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// principals are listed to evaluate the user rules against, which audit log events don't carry
+	if len(s.UserRules) > 0 {
+		s.eventPrincipals.Expire(eventPrincipalsRefresh)
+		if err := s.eventPrincipals.Load(ctx); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	feed := &eventFeed{
+		groups:     s.groups,
+		filter:     s.OrgFilter,
+		principals: s.eventPrincipals,
+		roles:      make(map[string][]snyk.Role),
+		orgs:       make(map[string]map[string]*snyk.Org),
+	}
+
+	var rv []*v2.Event
+	hasMore := false
+	for _, scope := range scopes {
+		pos, ok := cursor.Scopes[scope.key]
+		if !ok {
+			pos = &auditLogPosition{From: start}
+			cursor.Scopes[scope.key] = pos
+		}
+
+		items, next, err := scope.search(ctx, &snyk.AuditLogQuery{From: pos.From, Size: size}, pos.Next)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("snyk-connector: failed to search audit logs of %s: %w", scope.key, err)
+		}
+
+		for i := range items {
+			item := &items[i]
+
+			id, ok := pos.advance(item)
+			if !ok {
+				continue
+			}
+
+			events, err := feed.translate(ctx, scope.groupID, id, item)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			rv = append(rv, events...)
+		}
+
+		pos.Next = next
+		if next != "" {
+			hasMore = true
+		}
+	}
+
+	slices.SortStableFunc(rv, func(a, b *v2.Event) int {
+		return a.OccurredAt.AsTime().Compare(b.OccurredAt.AsTime())
+	})
+
+	state, err := json.Marshal(cursor)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return rv, &pagination.StreamState{Cursor: string(state), HasMore: hasMore}, nil, nil
+}
+
+// auditLogScopes returns the synced groups, or the organizations selected by the org filter in org-token mode.
+func (s *Snyk) auditLogScopes(ctx context.Context) ([]auditLogScope, error) {
+	var rv []auditLogScope
+	if !s.groups.OrgTokenMode() {
+		for _, groupID := range s.groups.ids {
+			rv = append(rv, auditLogScope{
+				key:     "group:" + groupID,
+				groupID: groupID,
+				search:  s.groups.clients[groupID].SearchGroupAuditLogs,
+			})
+		}
+
+		return rv, nil
+	}
+
+	client := s.groups.orgClient
+	orgs, err := client.ListCurrentUserOrgs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to list orgs: %w", err)
+	}
+
+	for _, org := range orgs {
+		if !s.OrgFilter.Match(&org) {
+			continue
+		}
+
+		orgID := org.ID
+		rv = append(rv, auditLogScope{
+			key: "org:" + orgID,
+			search: func(ctx context.Context, query *snyk.AuditLogQuery, next string) ([]snyk.AuditLogEvent, string, error) {
+				return client.SearchOrgAuditLogs(ctx, orgID, query, next)
+			},
+		})
+	}
+
+	return rv, nil
+}
+
+// translate returns the access changes recorded by the audit log event. Events that aren't about
+// memberships, roles or logins, events of organizations excluded by the org filter and events of
+// principals excluded by the user rules are ignored.
+func (f *eventFeed) translate(ctx context.Context, groupID, id string, item *snyk.AuditLogEvent) ([]*v2.Event, error) {
+	l := ctxzap.Extract(ctx)
+
+	if isLoginEvent(item) && f.principals.IsExcluded(item.UserID) {
+		return nil, nil
+	}
+
+	var parentID *v2.ResourceId
+	if groupID != "" {
+		parentID = &v2.ResourceId{ResourceType: groupResourceType.Id, Resource: groupID}
+	}
+
+	if item.OrgID == "" {
+		if parentID == nil {
+			return nil, nil
+		}
+
+		if f.principals.IsExcluded(auditString(item.Content, auditUserField)) {
+			return nil, nil
+		}
+
+		return groupEvents(id, item, &v2.Resource{Id: parentID}), nil
+	}
+
+	ok, err := f.include(ctx, groupID, item.OrgID)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	org := &v2.Resource{
+		Id:               &v2.ResourceId{ResourceType: orgResourceType.Id, Resource: item.OrgID},
+		ParentResourceId: parentID,
+	}
+
+	if isLoginEvent(item) {
+		return []*v2.Event{newUsageEvent(id, item, org, item.UserID)}, nil
+	}
+
+	var userID string
+	switch item.Event {
+	case auditOrgUserAdd, auditOrgUserRemove, auditOrgUserRoleEdit:
+		userID = auditString(item.Content, auditUserField)
+	case auditOrgUserInviteAccept, auditOrgUserLeave:
+		// the users accepting the invite or leaving made the change themselves
+		userID = auditString(item.Content, auditUserField)
+		if userID == "" {
+			userID = item.UserID
+		}
+	default:
+		return nil, nil
+	}
+
+	if userID == "" {
+		l.Debug("snyk-connector: audit log event doesn't identify the user", zap.String("event", item.Event), zap.String("org_id", item.OrgID))
+		return nil, nil
+	}
+
+	if f.principals.IsExcluded(userID) {
+		return nil, nil
+	}
+
+	principal := &v2.Resource{Id: &v2.ResourceId{ResourceType: userResourceType.Id, Resource: userID}}
+
+	previous, role, err := f.auditRoles(ctx, groupID, item)
+	if err != nil {
+		return nil, err
+	}
+
+	var rv []*v2.Event
+	switch item.Event {
+	case auditOrgUserAdd, auditOrgUserInviteAccept:
+		rv = append(rv, newGrantEvent(id, item, org, OrgMemberEntitlement, principal))
+		if role != nil {
+			rv = append(rv, newGrantEvent(id+":role", item, org, role.ID, principal))
+		}
+	case auditOrgUserRoleEdit:
+		if role != nil {
+			rv = append(rv, newGrantEvent(id, item, org, role.ID, principal))
+		}
+		if previous != nil && (role == nil || previous.ID != role.ID) {
+			rv = append(rv, newRevokeEvent(id+":previous", item, org, previous.ID, principal))
+		}
+	case auditOrgUserRemove, auditOrgUserLeave:
+		rv = append(rv, newRevokeEvent(id, item, org, OrgMemberEntitlement, principal))
+		if previous != nil {
+			rv = append(rv, newRevokeEvent(id+":role", item, org, previous.ID, principal))
+		}
+	}
+
+	return rv, nil
+}
+
+// groupEvents translates changes of group memberships, which are granted through the group role entitlements.
+func groupEvents(id string, item *snyk.AuditLogEvent, group *v2.Resource) []*v2.Event {
+	if isLoginEvent(item) {
+		return []*v2.Event{newUsageEvent(id, item, group, item.UserID)}
+	}
+
+	userID := auditString(item.Content, auditUserField)
+	if userID == "" {
+		return nil
+	}
+
+	principal := &v2.Resource{Id: &v2.ResourceId{ResourceType: userResourceType.Id, Resource: userID}}
+	role := groupRole(auditString(item.Content, "role", "newRole", "after"))
+	previous := groupRole(auditString(item.Content, "previousRole", "oldRole", "before"))
+
+	var rv []*v2.Event
+	switch item.Event {
+	case auditGroupUserAdd, auditGroupUserRoleEdit:
+		if role != "" {
+			rv = append(rv, newGrantEvent(id, item, group, role, principal))
+		}
+		if previous != "" && previous != role {
+			rv = append(rv, newRevokeEvent(id+":previous", item, group, previous, principal))
+		}
+	case auditGroupUserRemove:
+		if previous == "" {
+			previous = role
+		}
+		if previous != "" {
+			rv = append(rv, newRevokeEvent(id, item, group, previous, principal))
+		}
+	}
+
+	return rv
+}
+
+// isLoginEvent reports whether the event records a user logging in, which is translated into usage of the scope.
+func isLoginEvent(item *snyk.AuditLogEvent) bool {
+	return strings.HasSuffix(item.Event, ".login") && item.UserID != ""
+}
+
+// groupRole returns the group role entitlement matching the role of the audit log, if any.
+func groupRole(role string) string {
+	role = strings.ToLower(role)
+	if slices.Contains([]string{AdminRole, MemberRole, ViewerRole}, role) {
+		return role
+	}
+
+	return ""
+}
+
+// include reports whether the organization of the group is selected by the org filter.
+func (f *eventFeed) include(ctx context.Context, groupID, orgID string) (bool, error) {
+	// organizations are filtered when listing the scopes in org-token mode
+	if f.filter.IsEmpty() || groupID == "" {
+		return true, nil
+	}
+
+	orgs, ok := f.orgs[groupID]
+	if !ok {
+		client, err := f.groups.Get(groupID)
+		if err != nil {
+			return false, err
+		}
+
+		groupOrgs, err := listAllOrgs(ctx, client)
+		if err != nil {
+			return false, fmt.Errorf("snyk-connector: failed to list orgs in group %s: %w", groupID, err)
+		}
+
+		orgs = make(map[string]*snyk.Org, len(groupOrgs))
+		for i := range groupOrgs {
+			orgs[groupOrgs[i].ID] = &groupOrgs[i]
+		}
+		f.orgs[groupID] = orgs
+	}
+
+	org, ok := orgs[orgID]
+	return ok && f.filter.Match(org), nil
+}
+
+// auditRoles resolves the previous and new org roles recorded by the audit log event.
+// Roles that aren't recorded or can't be found in the group are returned as nil.
+func (f *eventFeed) auditRoles(ctx context.Context, groupID string, item *snyk.AuditLogEvent) (*snyk.Role, *snyk.Role, error) {
+	previousName := auditString(item.Content, "previousRole", "oldRole", "before")
+	roleName := auditString(item.Content, "role", "newRole", "after")
+	if previousName == "" && roleName == "" {
+		return nil, nil, nil
+	}
+
+	roles, ok := f.roles[groupID]
+	if !ok {
+		roles = orgTokenRoles
+		if groupID != "" {
+			client, err := f.groups.Get(groupID)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			roles, err = client.ListOrgRoles(ctx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("snyk-connector: failed to list roles in group %s: %w", groupID, err)
+			}
+		}
+		f.roles[groupID] = roles
+	}
+
+	previous, _ := findOrgRole(roles, previousName)
+	role, _ := findOrgRole(roles, roleName)
+
+	return previous, role, nil
+}
+
+// auditString returns the first non-empty value of the keys in the event content.
+// Values recorded as objects, like the state before and after the change, are searched for the role.
+func auditString(content map[string]any, keys ...string) string {
+	for _, key := range keys {
+		switch v := content[key].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case map[string]any:
+			if s := auditString(v, "role", "name", "publicId"); s != "" {
+				return s
+			}
+		}
+	}
+
+	return ""
+}
+
+// auditEventID identifies the audit log event, which has no ID of its own.
+func auditEventID(item *snyk.AuditLogEvent) string {
+	data, _ := json.Marshal(item)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+func newGrantEvent(id string, item *snyk.AuditLogEvent, resource *v2.Resource, entitlement string, principal *v2.Resource) *v2.Event {
+	return &v2.Event{
+		Id:         id,
+		OccurredAt: timestamppb.New(item.Created),
+		Event: &v2.Event_GrantEvent{
+			GrantEvent: &v2.GrantEvent{
+				Grant: grant.NewGrant(resource, entitlement, principal.Id),
+			},
+		},
+	}
+}
+
+func newRevokeEvent(id string, item *snyk.AuditLogEvent, resource *v2.Resource, entitlement string, principal *v2.Resource) *v2.Event {
+	return &v2.Event{
+		Id:         id,
+		OccurredAt: timestamppb.New(item.Created),
+		Event: &v2.Event_RevokeEvent{
+			RevokeEvent: &v2.RevokeEvent{
+				Entitlement: &v2.Entitlement{
+					Id:       ent.NewEntitlementID(resource, entitlement),
+					Resource: resource,
+					Slug:     entitlement,
+				},
+				Principal: principal,
+			},
+		},
+	}
+}
+
+func newUsageEvent(id string, item *snyk.AuditLogEvent, target *v2.Resource, userID string) *v2.Event {
+	return &v2.Event{
+		Id:         id,
+		OccurredAt: timestamppb.New(item.Created),
+		Event: &v2.Event_UsageEvent{
+			UsageEvent: &v2.UsageEvent{
+				TargetResource: target,
+				ActorResource:  &v2.Resource{Id: &v2.ResourceId{ResourceType: userResourceType.Id, Resource: userID}},
+			},
+		},
+	}
+}
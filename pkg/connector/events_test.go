@@ -0,0 +1,106 @@
+package connector
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+)
+
+func TestAuditLogPositionAdvance(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(name string, offset time.Duration) snyk.AuditLogEvent {
+		return snyk.AuditLogEvent{
+			Created: start.Add(offset),
+			Event:   auditOrgUserAdd,
+			OrgID:   "org",
+			Content: map[string]any{auditUserField: name},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		pages [][]snyk.AuditLogEvent
+		want  []string
+	}{
+		{
+			name:  "events of a single page",
+			pages: [][]snyk.AuditLogEvent{{event("a", 0), event("b", time.Second)}},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "events before the start are skipped",
+			pages: [][]snyk.AuditLogEvent{{event("a", -time.Second), event("b", 0)}},
+			want:  []string{"b"},
+		},
+		{
+			name: "event at the end of a page is returned again by the next search",
+			pages: [][]snyk.AuditLogEvent{
+				{event("a", 0), event("b", time.Second)},
+				{event("b", time.Second), event("c", 2*time.Second)},
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "events sharing a timestamp across pages",
+			pages: [][]snyk.AuditLogEvent{
+				{event("a", time.Second), event("b", time.Second)},
+				{event("a", time.Second), event("b", time.Second), event("c", time.Second)},
+				{event("c", time.Second), event("d", 2*time.Second)},
+			},
+			want: []string{"a", "b", "c", "d"},
+		},
+		{
+			name: "events older than the position are skipped",
+			pages: [][]snyk.AuditLogEvent{
+				{event("a", 0), event("b", 2*time.Second)},
+				{event("c", time.Second), event("d", 3*time.Second)},
+			},
+			want: []string{"a", "b", "d"},
+		},
+		{
+			name: "duplicate event within a page",
+			pages: [][]snyk.AuditLogEvent{
+				{event("a", 0), event("a", 0)},
+			},
+			want: []string{"a"},
+		},
+		{
+			name:  "empty pages keep the position",
+			pages: [][]snyk.AuditLogEvent{{event("a", 0)}, {}, {event("a", 0), event("b", time.Second)}},
+			want:  []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := &auditLogPosition{From: start}
+
+			var got []string
+			for _, page := range tt.pages {
+				for i := range page {
+					if _, ok := pos.advance(&page[i]); ok {
+						got = append(got, auditString(page[i].Content, auditUserField))
+					}
+				}
+
+				// the position is carried to the next page in the stream cursor
+				data, err := json.Marshal(pos)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				pos = &auditLogPosition{}
+				if err := json.Unmarshal(data, pos); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("advance returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// groupClients holds clients of all synced groups in the configured order
+// and resolves which group an organization belongs to.
+//
+// Without any group configured, the connector runs in org-token mode: organizations the token
+// has access to are synced directly, through the client that isn't scoped to any group.
+type groupClients struct {
+	ids       []string
+	clients   map[string]*snyk.Client
+	orgClient *snyk.Client
+
+	mtx       sync.Mutex
+	orgGroups map[string]string
+}
+
+func newGroupClients(client *snyk.Client, groupIDs []string) *groupClients {
+	clients := make(map[string]*snyk.Client, len(groupIDs))
+	for _, groupID := range groupIDs {
+		clients[groupID] = client.ForGroup(groupID)
+	}
+
+	return &groupClients{
+		ids:     groupIDs,
+		clients: clients,
+	}
+}
+
+func newOrgTokenClients(client *snyk.Client) *groupClients {
+	return &groupClients{
+		clients:   map[string]*snyk.Client{},
+		orgClient: client.ForGroup(""),
+	}
+}
+
+// OrgTokenMode reports whether organizations are synced without a group.
+func (g *groupClients) OrgTokenMode() bool {
+	return g.orgClient != nil
+}
+
+// Get returns client of the group.
+func (g *groupClients) Get(groupID string) (*snyk.Client, error) {
+	client, ok := g.clients[groupID]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "snyk-connector: group %s is not part of the sync", groupID)
+	}
+
+	return client, nil
+}
+
+// ForParent returns client of the group identified by the parent resource.
+func (g *groupClients) ForParent(parentResourceID *v2.ResourceId) (*snyk.Client, error) {
+	if g.OrgTokenMode() && parentResourceID == nil {
+		return g.orgClient, nil
+	}
+
+	if parentResourceID == nil || parentResourceID.ResourceType != groupResourceType.Id {
+		return nil, status.Errorf(codes.InvalidArgument, "snyk-connector: expected group parent resource, got %v", parentResourceID)
+	}
+
+	return g.Get(parentResourceID.Resource)
+}
+
+// ForOrg returns client of the group the organization belongs to.
+// The parent resource of the organization is used when known, otherwise organizations of all groups are looked up.
+func (g *groupClients) ForOrg(ctx context.Context, org *v2.Resource) (*snyk.Client, error) {
+	if g.OrgTokenMode() {
+		return g.orgClient, nil
+	}
+
+	if org.ParentResourceId != nil && org.ParentResourceId.ResourceType == groupResourceType.Id {
+		return g.Get(org.ParentResourceId.Resource)
+	}
+
+	if len(g.ids) == 1 {
+		return g.clients[g.ids[0]], nil
+	}
+
+	orgID := org.Id.Resource
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	groupID, ok := g.orgGroups[orgID]
+	if !ok {
+		// refresh the mapping, the organization could have been created since the last lookup
+		orgGroups := make(map[string]string)
+		for _, id := range g.ids {
+			orgs, err := listAllOrgs(ctx, g.clients[id])
+			if err != nil {
+				return nil, fmt.Errorf("snyk-connector: failed to list orgs in group %s: %w", id, err)
+			}
+
+			for _, o := range orgs {
+				orgGroups[o.ID] = id
+			}
+		}
+
+		g.orgGroups = orgGroups
+
+		if groupID, ok = orgGroups[orgID]; !ok {
+			return nil, status.Errorf(codes.NotFound, "snyk-connector: org %s not found in any of the synced groups", orgID)
+		}
+	}
+
+	return g.clients[groupID], nil
+}
+
+// listOrgTokenOrgs returns organizations accessible with the token that are selected by the filter.
+func listOrgTokenOrgs(ctx context.Context, client *snyk.Client, filter *OrgFilter) ([]snyk.Org, error) {
+	orgs, err := client.ListCurrentUserOrgs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to list orgs accessible with the token: %w", err)
+	}
+
+	var rv []snyk.Org
+	for i := range orgs {
+		if filter.Match(&orgs[i]) {
+			rv = append(rv, orgs[i])
+		}
+	}
+
+	return rv, nil
+}
+
+// forEachOrgTokenUser calls fn once for every member of the synced organizations accessible with the token.
+// Org members don't carry a group role, so only the user details of the returned group user are set.
+func forEachOrgTokenUser(ctx context.Context, client *snyk.Client, filter *OrgFilter, fn func(user *snyk.GroupUser) error) error {
+	orgs, err := listOrgTokenOrgs(ctx, client, filter)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{})
+	for _, org := range orgs {
+		err := client.ForEachUserInOrg(ctx, org.ID, func(member *snyk.OrgUser) error {
+			if _, ok := seen[member.ID]; ok {
+				return nil
+			}
+
+			seen[member.ID] = struct{}{}
+			return fn(&snyk.GroupUser{BaseUser: member.BaseUser})
+		})
+		if err != nil {
+			return fmt.Errorf("snyk-connector: failed to list users in org %s: %w", org.ID, err)
+		}
+	}
+
+	return nil
+}
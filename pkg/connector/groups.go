@@ -23,15 +23,16 @@ const (
 var groupRoles = []string{AdminRole, MemberRole, ViewerRole}
 
 type groupBuilder struct {
-	client *snyk.Client
-	ID     string
+	groups     *groupClients
+	tenant     *tenantScope
+	principals *principalIndex
 }
 
 func (g *groupBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 	return groupResourceType
 }
 
-func groupResource(ctx context.Context, group *snyk.Group) (*v2.Resource, error) {
+func groupResource(ctx context.Context, group *snyk.Group, parentID *v2.ResourceId) (*v2.Resource, error) {
 	profile := map[string]interface{}{
 		"displayName": group.Name,
 		"url":         group.URL,
@@ -48,6 +49,7 @@ func groupResource(ctx context.Context, group *snyk.Group) (*v2.Resource, error)
 			&v2.ChildResourceType{ResourceTypeId: orgResourceType.Id},
 			&v2.ChildResourceType{ResourceTypeId: userResourceType.Id},
 		),
+		rs.WithParentResourceID(parentID),
 	)
 	if err != nil {
 		return nil, err
@@ -58,21 +60,38 @@ func groupResource(ctx context.Context, group *snyk.Group) (*v2.Resource, error)
 
 // List returns all the groups from the database as resource objects.
 // Groups include a GroupTrait because they are the 'shape' of a standard group.
+// With tenant scope, groups are listed as children of the tenant instead of the top level resources.
 func (g *groupBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
 	var rv []*v2.Resource
 
-	// get details from orgs endpoint
-	groupDetail, err := g.client.GetGroupDetails(ctx)
+	nested, err := g.tenant.Nested(ctx)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("failed to get group details: %w", err)
+		return nil, "", nil, err
 	}
 
-	gr, err := groupResource(ctx, groupDetail)
-	if err != nil {
-		return nil, "", nil, err
+	if nested != (parentResourceID != nil && parentResourceID.ResourceType == tenantResourceType.Id) {
+		return nil, "", nil, nil
 	}
 
-	rv = append(rv, gr)
+	if !nested {
+		// groups are the root of the sync - drop principals indexed by the previous one
+		g.principals.Reset()
+	}
+
+	for _, groupID := range g.groups.ids {
+		// get details from orgs endpoint
+		groupDetail, err := g.groups.clients[groupID].GetGroupDetails(ctx)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to get group details for group %s: %w", groupID, err)
+		}
+
+		gr, err := groupResource(ctx, groupDetail, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, gr)
+	}
 
 	return rv, "", nil, nil
 }
@@ -98,29 +117,43 @@ func (g *groupBuilder) Entitlements(ctx context.Context, resource *v2.Resource,
 func (g *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
 	var rv []*v2.Grant
 
-	members, err := g.client.ListUsersInGroup(ctx)
+	client, err := g.groups.Get(resource.Id.Resource)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list users in group: %w", err)
+		return nil, "", nil, err
+	}
+
+	if err := g.principals.Load(ctx); err != nil {
+		return nil, "", nil, err
 	}
 
 	// permission grants
-	for _, member := range members {
+	err = client.ForEachUserInGroup(ctx, func(member *snyk.GroupUser) error {
+		if g.principals.IsExcluded(member.ID) {
+			return nil
+		}
+
 		userId, err := rs.NewResourceID(userResourceType, member.ID)
 		if err != nil {
-			return nil, "", nil, fmt.Errorf("snyk-connector: failed to create user resource id: %w", err)
+			return fmt.Errorf("snyk-connector: failed to create user resource id: %w", err)
 		}
 
 		if slices.Contains(groupRoles, member.Role) {
 			rv = append(rv, grant.NewGrant(resource, member.Role, userId))
 		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list users in group: %w", err)
 	}
 
 	return rv, "", nil, nil
 }
 
-func newGroupBuilder(client *snyk.Client, id string) *groupBuilder {
+func newGroupBuilder(groups *groupClients, tenant *tenantScope, principals *principalIndex) *groupBuilder {
 	return &groupBuilder{
-		client: client,
-		ID:     id,
+		groups:     groups,
+		tenant:     tenant,
+		principals: principals,
 	}
 }
@@ -1,12 +1,15 @@
 package connector
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
 )
 
 const ResourcesPageSize uint = 50
@@ -53,3 +56,27 @@ func parseLink(link string) (string, error) {
 
 	return url, fmt.Errorf("no next link found in header")
 }
+
+// alreadyGranted reports a grant that has nothing to do, e.g. when retried, as a success
+// annotated with GrantAlreadyExists.
+func alreadyGranted(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	ctxzap.Extract(ctx).Info(
+		"snyk-connector: grant already exists",
+		zap.String("principal_id", principal.Id.Resource),
+		zap.String("entitlement_id", entitlement.Id),
+	)
+
+	return annotations.New(&v2.GrantAlreadyExists{}), nil
+}
+
+// alreadyRevoked reports a revoke that has nothing to do, e.g. when retried, as a success
+// annotated with GrantAlreadyRevoked.
+func alreadyRevoked(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	ctxzap.Extract(ctx).Info(
+		"snyk-connector: grant already revoked",
+		zap.String("principal_id", principal.Id.Resource),
+		zap.String("entitlement_id", entitlement.Id),
+	)
+
+	return annotations.New(&v2.GrantAlreadyRevoked{}), nil
+}
@@ -0,0 +1,359 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultFullSyncInterval = 24 * time.Hour
+
+	// auditLogRetention is how long Snyk keeps audit logs, changes before that can't be caught up with.
+	auditLogRetention = 90 * 24 * time.Hour
+	// checkpointOverlap makes the audit log search overlap the previous sync, so events logged late aren't missed.
+	checkpointOverlap = 5 * time.Minute
+
+	syncSnapshotVersion = 1
+)
+
+// snapshotState is the persisted result of the last complete sync.
+type snapshotState struct {
+	Version int `json:"version"`
+	// Checkpoint is when the sync started, changes logged since then are read on the next sync.
+	Checkpoint time.Time `json:"checkpoint"`
+	// FullSync is when the last sync listing members of every organization started.
+	FullSync time.Time               `json:"full_sync"`
+	Orgs     map[string]*snapshotOrg `json:"orgs"`
+}
+
+type snapshotOrg struct {
+	GroupID string         `json:"group_id"`
+	Members []snyk.OrgUser `json:"members"`
+}
+
+// syncSnapshot serves members of organizations unchanged since the previous sync from its snapshot, so only
+// organizations the audit logs report changes in are listed again. Members of every synced organization,
+// served from the snapshot or listed, are merged into a new snapshot, saved once all of them were synced.
+type syncSnapshot struct {
+	path     string
+	interval time.Duration
+	groups   *groupClients
+	filter   *OrgFilter
+
+	mtx         sync.Mutex
+	loaded      bool
+	previous    *snapshotState
+	dirtyOrgs   map[string]struct{}
+	dirtyGroups map[string]struct{}
+	pending     map[string]struct{}
+	next        *snapshotState
+}
+
+func newSyncSnapshot(path string, interval time.Duration, groups *groupClients, filter *OrgFilter) *syncSnapshot {
+	if path == "" {
+		return nil
+	}
+
+	if interval <= 0 {
+		interval = DefaultFullSyncInterval
+	}
+
+	return &syncSnapshot{
+		path:     path,
+		interval: interval,
+		groups:   groups,
+		filter:   filter,
+	}
+}
+
+// Members returns members of the organization from the previous sync, if they didn't change since.
+// Members served from the snapshot are recorded for the next sync.
+func (s *syncSnapshot) Members(ctx context.Context, groupID, orgID string) ([]snyk.OrgUser, bool, error) {
+	if s == nil {
+		return nil, false, nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err := s.ensureLoaded(ctx, orgID); err != nil {
+		return nil, false, err
+	}
+
+	org := s.unchanged(groupID, orgID)
+	if org == nil {
+		return nil, false, nil
+	}
+
+	s.record(ctx, groupID, orgID, org.Members)
+	return org.Members, true, nil
+}
+
+// Unchanged reports whether members of the organization can be served from the snapshot.
+func (s *syncSnapshot) Unchanged(ctx context.Context, groupID, orgID string) (bool, error) {
+	if s == nil {
+		return false, nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err := s.ensureLoaded(ctx, orgID); err != nil {
+		return false, err
+	}
+
+	return s.unchanged(groupID, orgID) != nil, nil
+}
+
+// Record stores members of the organization listed during the sync.
+func (s *syncSnapshot) Record(ctx context.Context, groupID, orgID string, members []snyk.OrgUser) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if err := s.ensureLoaded(ctx, orgID); err != nil {
+		return err
+	}
+
+	s.record(ctx, groupID, orgID, members)
+	return nil
+}
+
+func (s *syncSnapshot) unchanged(groupID, orgID string) *snapshotOrg {
+	if s.previous == nil {
+		return nil
+	}
+
+	if _, ok := s.dirtyGroups[groupID]; ok {
+		return nil
+	}
+
+	if _, ok := s.dirtyOrgs[orgID]; ok {
+		return nil
+	}
+
+	org, ok := s.previous.Orgs[orgID]
+	if !ok || org.GroupID != groupID {
+		return nil
+	}
+
+	return org
+}
+
+// record adds members of the organization to the next snapshot and saves it once every organization is synced.
+func (s *syncSnapshot) record(ctx context.Context, groupID, orgID string, members []snyk.OrgUser) {
+	s.next.Orgs[orgID] = &snapshotOrg{GroupID: groupID, Members: members}
+	delete(s.pending, orgID)
+
+	if len(s.pending) > 0 {
+		return
+	}
+
+	// the next sync starts from the saved snapshot
+	s.loaded = false
+
+	l := ctxzap.Extract(ctx)
+	if err := s.save(); err != nil {
+		l.Error("snyk-connector: failed to save sync snapshot", zap.String("path", s.path), zap.Error(err))
+		return
+	}
+
+	l.Info("snyk-connector: saved sync snapshot", zap.String("path", s.path), zap.Int("orgs", len(s.next.Orgs)))
+}
+
+// ensureLoaded starts a new sync from the previous snapshot. Organizations synced again mean
+// the previous sync didn't complete and a new one started.
+func (s *syncSnapshot) ensureLoaded(ctx context.Context, orgID string) error {
+	if s.loaded {
+		_, synced := s.next.Orgs[orgID]
+		if !synced {
+			return nil
+		}
+	}
+
+	l := ctxzap.Extract(ctx)
+	start := time.Now()
+
+	s.pending = make(map[string]struct{})
+	for _, groupID := range s.groups.ids {
+		orgs, err := listAllOrgs(ctx, s.groups.clients[groupID])
+		if err != nil {
+			return fmt.Errorf("snyk-connector: failed to list orgs in group %s: %w", groupID, err)
+		}
+
+		for i := range orgs {
+			if s.filter.Match(&orgs[i]) {
+				s.pending[orgs[i].ID] = struct{}{}
+			}
+		}
+	}
+
+	s.next = &snapshotState{
+		Version:    syncSnapshotVersion,
+		Checkpoint: start,
+		FullSync:   start,
+		Orgs:       make(map[string]*snapshotOrg, len(s.pending)),
+	}
+	s.previous = nil
+	s.dirtyOrgs = make(map[string]struct{})
+	s.dirtyGroups = make(map[string]struct{})
+	s.loaded = true
+
+	previous, reason, err := s.load(start)
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		if err := s.readChanges(ctx, previous); err != nil {
+			l.Warn("snyk-connector: failed to read changes from audit logs", zap.Error(err))
+			previous, reason = nil, "audit logs unavailable"
+		}
+	}
+
+	if previous == nil {
+		l.Info("snyk-connector: listing members of all organizations", zap.String("reason", reason))
+		s.dirtyOrgs = make(map[string]struct{})
+		s.dirtyGroups = make(map[string]struct{})
+		return nil
+	}
+
+	s.previous = previous
+	s.next.FullSync = previous.FullSync
+
+	l.Info(
+		"snyk-connector: syncing changes since the previous sync",
+		zap.Time("checkpoint", previous.Checkpoint),
+		zap.Int("changed_orgs", len(s.dirtyOrgs)),
+		zap.Int("changed_groups", len(s.dirtyGroups)),
+	)
+
+	return nil
+}
+
+// load returns the previous snapshot, or the reason a full sync is needed instead.
+func (s *syncSnapshot) load(now time.Time) (*snapshotState, string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, "no previous sync", nil
+		}
+
+		return nil, "", fmt.Errorf("snyk-connector: failed to read sync snapshot: %w", err)
+	}
+
+	var state snapshotState
+	switch {
+	case json.Unmarshal(data, &state) != nil:
+		return nil, "invalid snapshot", nil
+	case state.Version != syncSnapshotVersion:
+		return nil, "snapshot version changed", nil
+	case now.Sub(state.FullSync) >= s.interval:
+		return nil, "full sync interval elapsed", nil
+	case now.Sub(state.Checkpoint) >= auditLogRetention-checkpointOverlap:
+		return nil, "changes since the previous sync are no longer in the audit logs", nil
+	}
+
+	return &state, "", nil
+}
+
+// readChanges marks organizations with membership or role changes logged since the previous sync.
+func (s *syncSnapshot) readChanges(ctx context.Context, previous *snapshotState) error {
+	query := &snyk.AuditLogQuery{From: previous.Checkpoint.Add(-checkpointOverlap)}
+
+	for _, groupID := range s.groups.ids {
+		client := s.groups.clients[groupID]
+
+		next := ""
+		for {
+			items, link, err := client.SearchGroupAuditLogs(ctx, query, next)
+			if err != nil {
+				return fmt.Errorf("group %s: %w", groupID, err)
+			}
+
+			for i := range items {
+				s.markChanged(previous, groupID, &items[i])
+			}
+
+			if link == "" {
+				break
+			}
+			next = link
+		}
+	}
+
+	return nil
+}
+
+func (s *syncSnapshot) markChanged(previous *snapshotState, groupID string, item *snyk.AuditLogEvent) {
+	if !affectsMembers(item.Event) {
+		return
+	}
+
+	if item.OrgID != "" {
+		s.dirtyOrgs[item.OrgID] = struct{}{}
+		return
+	}
+
+	userID := auditString(item.Content, auditUserField)
+	role := groupRole(auditString(item.Content, "role", "newRole", "after"))
+	previousRole := groupRole(auditString(item.Content, "previousRole", "oldRole", "before"))
+
+	switch {
+	case item.Event == auditGroupUserRemove && userID != "":
+		// users removed from the group are removed from its organizations too
+		for orgID, org := range previous.Orgs {
+			for _, member := range org.Members {
+				if member.ID == userID {
+					s.dirtyOrgs[orgID] = struct{}{}
+					break
+				}
+			}
+		}
+	case (item.Event == auditGroupUserAdd || item.Event == auditGroupUserRoleEdit) &&
+		(role != "" || previousRole != "") && role != AdminRole && previousRole != AdminRole:
+		// only group admins are listed as members of every organization
+	default:
+		// changes of group admins and changes without the roles recorded may affect any organization of the group
+		s.dirtyGroups[groupID] = struct{}{}
+	}
+}
+
+// affectsMembers reports whether the audit log event changes members of an organization or their roles.
+func affectsMembers(event string) bool {
+	switch event {
+	case auditOrgUserAdd, auditOrgUserInviteAccept, auditOrgUserRemove, auditOrgUserLeave, auditOrgUserRoleEdit,
+		auditGroupUserAdd, auditGroupUserRemove, auditGroupUserRoleEdit:
+		return true
+	default:
+		return false
+	}
+}
+
+// save writes the next snapshot, replacing the previous one only once it is written completely.
+func (s *syncSnapshot) save() error {
+	data, err := json.Marshal(s.next)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
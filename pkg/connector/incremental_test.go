@@ -0,0 +1,124 @@
+package connector
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+)
+
+func TestSyncSnapshotMarkChanged(t *testing.T) {
+	member := func(id string) snyk.OrgUser {
+		return snyk.OrgUser{BaseUser: snyk.BaseUser{BaseResource: snyk.BaseResource{ID: id}}}
+	}
+
+	previous := &snapshotState{
+		Orgs: map[string]*snapshotOrg{
+			"org-1": {GroupID: "group", Members: []snyk.OrgUser{member("user-1"), member("user-2")}},
+			"org-2": {GroupID: "group", Members: []snyk.OrgUser{member("user-2")}},
+			"org-3": {GroupID: "group", Members: []snyk.OrgUser{member("user-3")}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		item        snyk.AuditLogEvent
+		dirtyOrgs   []string
+		dirtyGroups []string
+	}{
+		{
+			name:      "org member added",
+			item:      snyk.AuditLogEvent{Event: auditOrgUserAdd, OrgID: "org-1", Content: map[string]any{auditUserField: "user-4"}},
+			dirtyOrgs: []string{"org-1"},
+		},
+		{
+			name:      "org role changed",
+			item:      snyk.AuditLogEvent{Event: auditOrgUserRoleEdit, OrgID: "org-2"},
+			dirtyOrgs: []string{"org-2"},
+		},
+		{
+			name:      "org invite accepted",
+			item:      snyk.AuditLogEvent{Event: auditOrgUserInviteAccept, OrgID: "org-3", UserID: "user-4"},
+			dirtyOrgs: []string{"org-3"},
+		},
+		{
+			name: "org login",
+			item: snyk.AuditLogEvent{Event: "org.user.login", OrgID: "org-1", UserID: "user-1"},
+		},
+		{
+			name: "unrelated org event",
+			item: snyk.AuditLogEvent{Event: "org.project.add", OrgID: "org-1", UserID: "user-1"},
+		},
+		{
+			name: "unrelated group event",
+			item: snyk.AuditLogEvent{Event: "group.settings.edit", UserID: "user-1"},
+		},
+		{
+			name:      "group member removed from the orgs they were a member of",
+			item:      snyk.AuditLogEvent{Event: auditGroupUserRemove, Content: map[string]any{auditUserField: "user-2"}},
+			dirtyOrgs: []string{"org-1", "org-2"},
+		},
+		{
+			name:        "group member removal without the user",
+			item:        snyk.AuditLogEvent{Event: auditGroupUserRemove},
+			dirtyGroups: []string{"group"},
+		},
+		{
+			name: "group member added",
+			item: snyk.AuditLogEvent{Event: auditGroupUserAdd, Content: map[string]any{auditUserField: "user-4", "role": "member"}},
+		},
+		{
+			name:        "group admin added",
+			item:        snyk.AuditLogEvent{Event: auditGroupUserAdd, Content: map[string]any{auditUserField: "user-4", "role": "admin"}},
+			dirtyGroups: []string{"group"},
+		},
+		{
+			name:        "group member added without the role",
+			item:        snyk.AuditLogEvent{Event: auditGroupUserAdd, Content: map[string]any{auditUserField: "user-4"}},
+			dirtyGroups: []string{"group"},
+		},
+		{
+			name: "group role changed between non-admin roles",
+			item: snyk.AuditLogEvent{Event: auditGroupUserRoleEdit, Content: map[string]any{auditUserField: "user-1", "before": "viewer", "after": "member"}},
+		},
+		{
+			name:        "group admin demoted",
+			item:        snyk.AuditLogEvent{Event: auditGroupUserRoleEdit, Content: map[string]any{auditUserField: "user-1", "before": "admin", "after": "viewer"}},
+			dirtyGroups: []string{"group"},
+		},
+		{
+			name:        "group member promoted to admin",
+			item:        snyk.AuditLogEvent{Event: auditGroupUserRoleEdit, Content: map[string]any{auditUserField: "user-1", "before": "member", "after": "admin"}},
+			dirtyGroups: []string{"group"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &syncSnapshot{
+				dirtyOrgs:   make(map[string]struct{}),
+				dirtyGroups: make(map[string]struct{}),
+			}
+
+			s.markChanged(previous, "group", &tt.item)
+
+			if got := sortedKeys(s.dirtyOrgs); !slices.Equal(got, tt.dirtyOrgs) {
+				t.Errorf("dirty orgs = %v, want %v", got, tt.dirtyOrgs)
+			}
+
+			if got := sortedKeys(s.dirtyGroups); !slices.Equal(got, tt.dirtyGroups) {
+				t.Errorf("dirty groups = %v, want %v", got, tt.dirtyGroups)
+			}
+		})
+	}
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	var rv []string
+	for k := range m {
+		rv = append(rv, k)
+	}
+
+	slices.Sort(rv)
+	return rv
+}
@@ -0,0 +1,170 @@
+package connector
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	JournalAdd    = "add"
+	JournalUpdate = "update"
+	JournalRemove = "remove"
+
+	JournalScopeOrg    = "org"
+	JournalScopeTenant = "tenant"
+)
+
+// JournalEntry records a single membership change made in Snyk.
+// Org roles are recorded by their slug, tenant roles by their ID.
+type JournalEntry struct {
+	ID           string    `json:"id"`
+	Time         time.Time `json:"time"`
+	Scope        string    `json:"scope"`
+	Action       string    `json:"action"`
+	TenantID     string    `json:"tenant_id,omitempty"`
+	GroupID      string    `json:"group_id,omitempty"`
+	OrgID        string    `json:"org_id,omitempty"`
+	UserID       string    `json:"user_id"`
+	MembershipID string    `json:"membership_id,omitempty"`
+	PreviousRole string    `json:"previous_role,omitempty"`
+	NewRole      string    `json:"new_role,omitempty"`
+	// Undoes is the ID of the entry the change rolled back, if it was made by undo.
+	Undoes string `json:"undoes,omitempty"`
+}
+
+// provisioningJournal appends every membership change to a JSON lines file, so changes can be audited and undone.
+// A nil journal doesn't record anything, nor does a journal in dry run, since nothing is changed.
+type provisioningJournal struct {
+	path   string
+	dryRun bool
+
+	mtx sync.Mutex
+}
+
+func newProvisioningJournal(path string, dryRun bool) *provisioningJournal {
+	if path == "" {
+		return nil
+	}
+
+	return &provisioningJournal{
+		path:   path,
+		dryRun: dryRun,
+	}
+}
+
+type undoKey struct{}
+
+// withUndo marks changes made with the context as rolling back the journal entry.
+func withUndo(ctx context.Context, entryID string) context.Context {
+	return context.WithValue(ctx, undoKey{}, entryID)
+}
+
+func newOperationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// record appends the change to the journal. It is called after the change is made,
+// so a failure means Snyk was changed without the change being journaled.
+func (j *provisioningJournal) record(ctx context.Context, entry JournalEntry) error {
+	if j == nil || j.dryRun {
+		return nil
+	}
+
+	id, err := newOperationID()
+	if err != nil {
+		return fmt.Errorf("snyk-connector: change applied, but failed to journal it: %w", err)
+	}
+
+	entry.ID = id
+	entry.Time = time.Now().UTC()
+	if undoes, ok := ctx.Value(undoKey{}).(string); ok {
+		entry.Undoes = undoes
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: change applied, but failed to journal it: %w", err)
+	}
+
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: change applied, but failed to journal it: %w", err)
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("snyk-connector: change applied, but failed to journal it: %w", err)
+	}
+
+	ctxzap.Extract(ctx).Debug(
+		"snyk-connector: change journaled",
+		zap.String("operation_id", entry.ID),
+		zap.String("scope", entry.Scope),
+		zap.String("action", entry.Action),
+		zap.String("user_id", entry.UserID),
+	)
+
+	return nil
+}
+
+// Entries returns all entries of the journal in the order they were recorded.
+func (j *provisioningJournal) Entries() ([]JournalEntry, error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	f, err := os.Open(j.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("snyk-connector: failed to open journal: %w", err)
+	}
+	defer f.Close()
+
+	var rv []JournalEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("snyk-connector: invalid journal entry on line %d: %w", line, err)
+		}
+
+		rv = append(rv, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to read journal: %w", err)
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,424 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ChangeApplied = "applied"
+	ChangeSkipped = "skipped"
+	ChangeFailed  = "failed"
+
+	// implicitAdminReason is why roles of group admins, listed as admins of every organization, aren't changed.
+	implicitAdminReason = "admin of the group, not an explicit member of the organization"
+)
+
+// Manifest declares organization access as code: the role of every principal in each organization.
+// Organizations are identified by their ID or slug, principals by the user ID or email and roles by
+// their public ID, slug or name.
+//
+//	orgs:
+//	  my-org:
+//	    jane@example.com: admin
+//	    0e6f1f1e-29c1-4b16-a6a2-b4e1c4d1a6b9: collaborator
+type Manifest struct {
+	Orgs map[string]map[string]string `yaml:"orgs"`
+}
+
+// LoadManifest reads the manifest from a YAML or JSON file.
+func LoadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	// JSON is valid YAML, so both are decoded the same way
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	var m Manifest
+	if err := decoder.Decode(&m); err != nil {
+		return nil, fmt.Errorf("snyk-connector: invalid manifest %s: %w", path, err)
+	}
+
+	return &m, nil
+}
+
+// PlannedChange is a membership change converging an organization to the manifest.
+type PlannedChange struct {
+	Action       string
+	GroupID      string
+	OrgID        string
+	OrgName      string
+	UserID       string
+	Principal    string
+	PreviousRole string
+	NewRole      string
+	// Skipped is the reason the change can't be applied, e.g. the user is protected.
+	Skipped string
+
+	group *orgGroup
+	role  *snyk.Role
+}
+
+// rank orders changes so that admins are added before any admin is demoted or removed.
+func (c *PlannedChange) rank() int {
+	switch {
+	case c.Action == JournalAdd:
+		return 0
+	case c.Action == JournalUpdate && c.NewRole == snyk.OrgAdminRole:
+		return 1
+	case c.Action == JournalUpdate:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// ApplyResult is the outcome of applying a single planned change.
+type ApplyResult struct {
+	Change PlannedChange
+	Status string
+	Detail string
+}
+
+// resolvedOrg is an organization resolved to the group it belongs to.
+type resolvedOrg struct {
+	org   snyk.Org
+	group *orgGroup
+}
+
+// Plan diffs the manifest against live organization memberships and returns the changes converging them.
+// Members missing from the manifest are only removed when prune is set. Organizations missing from the
+// manifest are never changed.
+func (s *Snyk) Plan(ctx context.Context, manifest *Manifest, prune bool) ([]PlannedChange, error) {
+	orgs := newOrgBuilder(s.groups, s.OrgFilter, nil, s.policy, s.verifier, s.journal, nil, false, false, 0)
+
+	keys := make([]string, 0, len(manifest.Orgs))
+	for key := range manifest.Orgs {
+		keys = append(keys, key)
+	}
+
+	resolved, err := s.resolveOrgs(ctx, orgs, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	groupUsers := make(map[string]userIndex)
+
+	var rv []PlannedChange
+	for key, principals := range manifest.Orgs {
+		org := resolved[key]
+		group := org.group
+
+		roles, err := group.lookupRoles(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to list roles in org %s: %w", org.org.ID, err)
+		}
+
+		members, implicit, err := listOrgMembers(ctx, group.client, org.org.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		// members of the group can be added to its organizations, in org-token mode only members are known
+		users := make(userIndex)
+		if !group.orgToken {
+			groupID := group.client.GroupID()
+			if _, ok := groupUsers[groupID]; !ok {
+				groupUsers[groupID], err = listGroupUsers(ctx, group.client)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			users = groupUsers[groupID]
+		}
+
+		for _, member := range members {
+			users.add(member.BaseUser)
+		}
+
+		declared := make(map[string]struct{}, len(principals))
+		for principal, roleName := range principals {
+			role, ok := findOrgRole(roles, roleName)
+			if !ok {
+				return nil, fmt.Errorf("snyk-connector: role %s of %s in org %s not found", roleName, principal, key)
+			}
+
+			change := PlannedChange{
+				GroupID:   group.client.GroupID(),
+				OrgID:     org.org.ID,
+				OrgName:   org.org.Name,
+				Principal: principal,
+				NewRole:   role.Slug,
+				group:     group,
+				role:      role,
+			}
+
+			user, ok := users[strings.ToLower(principal)]
+			if !ok {
+				change.Action = JournalAdd
+				change.Skipped = "user not found"
+				rv = append(rv, change)
+				continue
+			}
+
+			if _, ok := declared[user.ID]; ok {
+				return nil, fmt.Errorf("snyk-connector: user %s is declared more than once in org %s", user.ID, key)
+			}
+
+			change.UserID = user.ID
+			declared[user.ID] = struct{}{}
+
+			i := slices.IndexFunc(members, func(m snyk.OrgUser) bool {
+				return m.ID == user.ID
+			})
+			if i == -1 {
+				change.Action = JournalAdd
+				if group.orgToken {
+					change.Skipped = fmt.Sprintf("adding users to organizations requires %s to be configured", GroupID)
+				}
+
+				rv = append(rv, change)
+				continue
+			}
+
+			member := &members[i]
+			if member.Role == role.Slug {
+				continue
+			}
+
+			change.Action = JournalUpdate
+			change.PreviousRole = member.Role
+			change.Skipped = s.protectedReason(ctx, member, role.Slug)
+			if _, ok := implicit[member.ID]; ok {
+				change.Skipped = implicitAdminReason
+			}
+			rv = append(rv, change)
+		}
+
+		if !prune {
+			continue
+		}
+
+		for i := range members {
+			member := &members[i]
+			if _, ok := declared[member.ID]; ok {
+				continue
+			}
+
+			// group admins are members of every organization and can't be removed from one
+			if _, ok := implicit[member.ID]; ok {
+				continue
+			}
+
+			rv = append(rv, PlannedChange{
+				Action:       JournalRemove,
+				GroupID:      group.client.GroupID(),
+				OrgID:        org.org.ID,
+				OrgName:      org.org.Name,
+				UserID:       member.ID,
+				Principal:    member.Email,
+				PreviousRole: member.Role,
+				Skipped:      s.protectedReason(ctx, member, ""),
+				group:        group,
+			})
+		}
+	}
+
+	sortChanges(rv)
+	return rv, nil
+}
+
+// sortChanges orders changes by their rank, then by organization and principal.
+func sortChanges(changes []PlannedChange) {
+	slices.SortStableFunc(changes, func(a, b PlannedChange) int {
+		if a.rank() != b.rank() {
+			return a.rank() - b.rank()
+		}
+
+		return strings.Compare(a.OrgName+a.Principal, b.OrgName+b.Principal)
+	})
+}
+
+// Apply makes the planned changes, admins being added first so the last-admin check doesn't refuse
+// replacing them. Changes go through the same protections as grants and revokes, are verified and journaled
+// when configured, and a failed change doesn't stop the remaining ones.
+func (s *Snyk) Apply(ctx context.Context, changes []PlannedChange) ([]ApplyResult, error) {
+	if s.ReadOnly {
+		return nil, fmt.Errorf("snyk-connector: the manifest can't be applied in %s mode", ReadOnly)
+	}
+
+	l := ctxzap.Extract(ctx)
+
+	rv := make([]ApplyResult, 0, len(changes))
+	for _, change := range changes {
+		result := ApplyResult{Change: change, Status: ChangeApplied}
+
+		var err error
+		if change.Skipped != "" {
+			result.Status, result.Detail = ChangeSkipped, change.Skipped
+		} else {
+			err = s.applyChange(ctx, &change)
+		}
+
+		if err != nil {
+			result.Status, result.Detail = ChangeFailed, err.Error()
+		}
+
+		l.Info(
+			"snyk-connector: apply",
+			zap.String("action", change.Action),
+			zap.String("org_id", change.OrgID),
+			zap.String("user_id", change.UserID),
+			zap.String("role", change.NewRole),
+			zap.String("status", result.Status),
+			zap.String("detail", result.Detail),
+		)
+
+		rv = append(rv, result)
+	}
+
+	return rv, nil
+}
+
+func (s *Snyk) applyChange(ctx context.Context, change *PlannedChange) error {
+	group := change.group
+
+	switch change.Action {
+	case JournalAdd:
+		return group.addMember(ctx, change.UserID, change.OrgID, change.role)
+	case JournalUpdate:
+		if change.NewRole != snyk.OrgAdminRole {
+			if err := s.policy.checkOrgAdmins(ctx, group.client, change.OrgID, change.UserID); err != nil {
+				return err
+			}
+		}
+
+		return group.setRole(ctx, change.UserID, change.OrgID, change.PreviousRole, change.role)
+	default:
+		if err := s.policy.checkOrgAdmins(ctx, group.client, change.OrgID, change.UserID); err != nil {
+			return err
+		}
+
+		return group.removeMember(ctx, change.UserID, change.OrgID, change.PreviousRole)
+	}
+}
+
+// listOrgMembers returns members of the organization, including admins of its group, and the IDs of the
+// group admins who are only implicit members of the organization.
+func listOrgMembers(ctx context.Context, client *snyk.Client, orgID string) ([]snyk.OrgUser, map[string]struct{}, error) {
+	members, err := client.ListUsersInOrg(ctx, orgID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("snyk-connector: failed to list users in org %s: %w", orgID, err)
+	}
+
+	explicit, err := client.ListExplicitUsersInOrg(ctx, orgID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("snyk-connector: failed to list users in org %s: %w", orgID, err)
+	}
+
+	implicit := make(map[string]struct{})
+	for _, member := range members {
+		implicit[member.ID] = struct{}{}
+	}
+
+	for _, member := range explicit {
+		delete(implicit, member.ID)
+	}
+
+	return members, implicit, nil
+}
+
+// protectedReason returns why the member can't be given the role (slug), or removed when it is empty, if it is
+// protected. Promotions to admin are always allowed.
+func (s *Snyk) protectedReason(ctx context.Context, member *snyk.OrgUser, role string) string {
+	if role == snyk.OrgAdminRole {
+		return ""
+	}
+
+	err := s.policy.checkProtected(ctx, member.ID, func(ctx context.Context) (string, error) { return member.Email, nil })
+	if err != nil {
+		return err.Error()
+	}
+
+	return ""
+}
+
+// resolveOrgs resolves organizations by their ID or slug.
+func (s *Snyk) resolveOrgs(ctx context.Context, orgs *orgBuilder, keys []string) (map[string]resolvedOrg, error) {
+	known := make(map[string]resolvedOrg)
+	add := func(group *orgGroup, list []snyk.Org) {
+		for _, org := range list {
+			known[org.ID] = resolvedOrg{org: org, group: group}
+			known[org.Slug] = resolvedOrg{org: org, group: group}
+		}
+	}
+
+	if s.groups.OrgTokenMode() {
+		list, err := s.groups.orgClient.ListCurrentUserOrgs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to list orgs accessible with the token: %w", err)
+		}
+
+		add(orgs.orgGroups[""], list)
+	}
+
+	for _, groupID := range s.groups.ids {
+		list, err := listAllOrgs(ctx, s.groups.clients[groupID])
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to list orgs in group %s: %w", groupID, err)
+		}
+
+		add(orgs.orgGroups[groupID], list)
+	}
+
+	rv := make(map[string]resolvedOrg, len(keys))
+	for _, key := range keys {
+		org, ok := known[key]
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "snyk-connector: org %s not found", key)
+		}
+
+		rv[key] = org
+	}
+
+	return rv, nil
+}
+
+// userIndex maps lowercased IDs and emails to users.
+type userIndex map[string]*snyk.BaseUser
+
+func (u userIndex) add(user snyk.BaseUser) {
+	u[strings.ToLower(user.ID)] = &user
+	if user.Email != "" {
+		u[strings.ToLower(user.Email)] = &user
+	}
+}
+
+// listGroupUsers returns members of the group principals of the manifest can refer to.
+func listGroupUsers(ctx context.Context, client *snyk.Client) (userIndex, error) {
+	rv := make(userIndex)
+	err := client.ForEachUserInGroup(ctx, func(user *snyk.GroupUser) error {
+		rv.add(user.BaseUser)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to list users in group: %w", err)
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,136 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// planSummary is a planned change in a comparable form, prefixed with "skip" when it can't be applied.
+func planSummary(changes []PlannedChange) []string {
+	var rv []string
+	for _, c := range changes {
+		s := fmt.Sprintf("%s %s (%s): %q -> %q", c.Action, c.Principal, c.UserID, c.PreviousRole, c.NewRole)
+		if c.Skipped != "" {
+			s = "skip " + s
+		}
+
+		rv = append(rv, s)
+	}
+
+	return rv
+}
+
+func TestManifestPlanApply(t *testing.T) {
+	ctx := context.Background()
+
+	f := newFakeSnyk(t)
+	f.orgs["org-1"] = []fakeMember{
+		{ID: "admin-1", Email: "admin@example.com", RoleID: "role-admin"},
+		{ID: "user-1", Email: "one@example.com", RoleID: "role-collaborator"},
+		{ID: "user-2", Email: "two@example.com", RoleID: "role-collaborator"},
+		{ID: "vip", Email: "vip@example.com", RoleID: "role-admin"},
+	}
+	f.orgs["org-2"] = []fakeMember{
+		{ID: "user-2", Email: "two@example.com", RoleID: "role-collaborator"},
+	}
+	f.users = []fakeMember{{ID: "user-3", Email: "three@example.com"}}
+	f.admins = []string{"group-admin"}
+
+	s := f.connector(&Config{ProtectedPrincipals: []string{"vip"}})
+
+	manifest := &Manifest{Orgs: map[string]map[string]string{
+		"org-1": {
+			"ADMIN@example.com":   "admin",
+			"user-1":              "Org Admin",
+			"three@example.com":   "collaborator",
+			"missing@example.com": "collaborator",
+			"group-admin":         "role-collaborator",
+		},
+	}}
+
+	plan, err := s.Plan(ctx, manifest, true)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	// admins are added first, members missing from the manifest removed last and org-2 isn't in the manifest
+	want := []string{
+		`skip add missing@example.com (): "" -> "collaborator"`,
+		`add three@example.com (user-3): "" -> "collaborator"`,
+		`update user-1 (user-1): "collaborator" -> "admin"`,
+		`skip update group-admin (group-admin): "admin" -> "collaborator"`,
+		`remove two@example.com (user-2): "collaborator" -> ""`,
+		`skip remove vip@example.com (vip): "admin" -> ""`,
+	}
+
+	if got := planSummary(plan); !slices.Equal(got, want) {
+		t.Fatalf("Plan returned\n%v\nwant\n%v", got, want)
+	}
+
+	results, err := s.Apply(ctx, plan)
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	for _, r := range results {
+		if r.Status == ChangeFailed {
+			t.Errorf("change %s of %s failed: %s", r.Change.Action, r.Change.Principal, r.Detail)
+		}
+	}
+
+	roles := []struct {
+		orgID, userID, role string
+	}{
+		{"org-1", "admin-1", "role-admin"},
+		{"org-1", "user-1", "role-admin"},
+		{"org-1", "user-2", ""},
+		{"org-1", "user-3", "role-collaborator"},
+		{"org-1", "vip", "role-admin"},
+		{"org-2", "user-2", "role-collaborator"},
+	}
+
+	for _, r := range roles {
+		if got := f.Role(r.orgID, r.userID); got != r.role {
+			t.Errorf("%s has role %q in %s after apply, want %q", r.userID, got, r.orgID, r.role)
+		}
+	}
+
+	// once applied, only the changes that can't be applied are left
+	plan, err = s.Plan(ctx, manifest, true)
+	if err != nil {
+		t.Fatalf("Plan after apply failed: %v", err)
+	}
+
+	want = []string{
+		`skip add missing@example.com (): "" -> "collaborator"`,
+		`skip update group-admin (group-admin): "admin" -> "collaborator"`,
+		`skip remove vip@example.com (vip): "admin" -> ""`,
+	}
+
+	if got := planSummary(plan); !slices.Equal(got, want) {
+		t.Errorf("Plan after apply returned\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestManifestPlanWithoutPrune(t *testing.T) {
+	f := newFakeSnyk(t)
+	f.orgs["org-1"] = []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "user-1", RoleID: "role-collaborator"},
+	}
+
+	s := f.connector(nil)
+
+	plan, err := s.Plan(context.Background(), &Manifest{Orgs: map[string]map[string]string{
+		"org-1": {"admin-1": "admin"},
+	}}, false)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if len(plan) > 0 {
+		t.Errorf("Plan returned %v, want no changes without prune", planSummary(plan))
+	}
+}
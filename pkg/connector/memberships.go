@@ -0,0 +1,182 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// orgMemberships resolves organization members from the single group members response
+// instead of listing members of every organization separately.
+//
+// The group members response identifies organizations by their ID when it provides one, and
+// by name otherwise, keyed to organization IDs through the group's organization list.
+// Organizations whose name is shared with another organization in the group can't be resolved
+// reliably and are reported as incomplete, so the caller falls back to listing their members directly.
+//
+// Roles are listed as the role slug or name, and are resolved against the org roles of the group
+// into the slug listed by the org members endpoint. Organizations with roles that can't be resolved
+// are reported as incomplete too.
+//
+// Members of every organization are held in memory until the next sync, trading memory for requests.
+// Without it, members of each organization are streamed from the API once per organization.
+type orgMemberships struct {
+	client *snyk.Client
+
+	mtx        sync.Mutex
+	loaded     bool
+	members    map[string][]snyk.OrgUser
+	incomplete map[string]struct{}
+}
+
+func newOrgMemberships(client *snyk.Client) *orgMemberships {
+	return &orgMemberships{
+		client: client,
+	}
+}
+
+// Reset drops the loaded memberships so the next lookup fetches them again.
+func (m *orgMemberships) Reset() {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.loaded = false
+	m.members = nil
+	m.incomplete = nil
+}
+
+// Members returns members of the organization and whether the group members response
+// contained complete information about it.
+func (m *orgMemberships) Members(ctx context.Context, orgID string) ([]snyk.OrgUser, bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if !m.loaded {
+		if err := m.load(ctx); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if _, ok := m.incomplete[orgID]; ok {
+		return nil, false, nil
+	}
+
+	return m.members[orgID], true, nil
+}
+
+func (m *orgMemberships) load(ctx context.Context) error {
+	l := ctxzap.Extract(ctx)
+
+	orgs, err := listAllOrgs(ctx, m.client)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to list orgs: %w", err)
+	}
+
+	roles, err := m.client.ListOrgRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to list org roles: %w", err)
+	}
+
+	orgIDsByName := make(map[string][]string, len(orgs))
+	for _, org := range orgs {
+		orgIDsByName[org.Name] = append(orgIDsByName[org.Name], org.ID)
+	}
+
+	members := make(map[string][]snyk.OrgUser, len(orgs))
+	incomplete := make(map[string]struct{})
+	err = m.client.ForEachUserInGroup(ctx, func(user *snyk.GroupUser) error {
+		explicit := make(map[string]struct{}, len(user.Orgs))
+
+		for _, userOrg := range user.Orgs {
+			orgIDs := orgIDsByName[userOrg.Name]
+			if userOrg.ID != "" {
+				orgIDs = []string{userOrg.ID}
+			}
+
+			if len(orgIDs) != 1 {
+				// organization name is either ambiguous or not part of the group listing
+				for _, orgID := range orgIDs {
+					incomplete[orgID] = struct{}{}
+				}
+
+				l.Debug(
+					"snyk-connector: unable to resolve organization from group membership",
+					zap.String("org_name", userOrg.Name),
+					zap.Int("matches", len(orgIDs)),
+				)
+				continue
+			}
+
+			orgID := orgIDs[0]
+			explicit[orgID] = struct{}{}
+
+			role, ok := findOrgRole(roles, userOrg.Role)
+			if !ok {
+				incomplete[orgID] = struct{}{}
+				l.Debug("snyk-connector: unable to resolve role from group membership", zap.String("org_id", orgID), zap.String("role", userOrg.Role))
+				continue
+			}
+
+			members[orgID] = append(members[orgID], snyk.OrgUser{
+				BaseUser: user.BaseUser,
+				Role:     role.Slug,
+			})
+		}
+
+		// group admins are listed as admins of every organization
+		// when listing organization members with group admins included
+		if user.Role != AdminRole {
+			return nil
+		}
+
+		for _, org := range orgs {
+			if _, ok := explicit[org.ID]; ok {
+				continue
+			}
+
+			members[org.ID] = append(members[org.ID], snyk.OrgUser{
+				BaseUser: user.BaseUser,
+				Role:     snyk.OrgAdminRole,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to list users in group: %w", err)
+	}
+
+	m.members = members
+	m.incomplete = incomplete
+	m.loaded = true
+
+	return nil
+}
+
+// listAllOrgs returns every organization in the group, following pagination links.
+func listAllOrgs(ctx context.Context, client *snyk.Client) ([]snyk.Org, error) {
+	var rv []snyk.Org
+
+	page := ""
+	for {
+		orgs, nextPageLink, err := client.ListOrgs(ctx, snyk.NewPaginationVars(page, ResourcesPageSize))
+		if err != nil {
+			return nil, err
+		}
+
+		rv = append(rv, orgs...)
+
+		page, err = parseLink(nextPageLink)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to parse link: %w", err)
+		}
+
+		if page == "" {
+			return rv, nil
+		}
+	}
+}
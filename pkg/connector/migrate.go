@@ -0,0 +1,370 @@
+package connector
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// RoleMigration moves every holder of an org role to another role in all synced organizations.
+type RoleMigration struct {
+	// FromRole and ToRole are public IDs of the org roles.
+	FromRole string
+	ToRole   string
+	// Concurrency limits the number of organizations migrated at once.
+	Concurrency int
+	// ProgressPath is the file completed organizations are appended to, so an interrupted migration can be
+	// resumed without listing members of organizations migrated already. Empty disables it.
+	ProgressPath string
+}
+
+// OrgMigration summarizes the role migration of a single organization.
+type OrgMigration struct {
+	FromRole string   `json:"from_role"`
+	ToRole   string   `json:"to_role"`
+	GroupID  string   `json:"group_id"`
+	OrgID    string   `json:"org_id"`
+	OrgName  string   `json:"org_name"`
+	Migrated int      `json:"migrated"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Errors   []string `json:"errors,omitempty"`
+	// Resumed reports the organization was migrated by a previous run.
+	Resumed bool `json:"-"`
+}
+
+// migrationJob is an organization to migrate along with the roles of its group.
+type migrationJob struct {
+	group    *orgGroup
+	org      snyk.Org
+	roles    []snyk.Role
+	from, to *snyk.Role
+}
+
+// MigrateRole assigns the target role to every member holding the source role, in all organizations
+// selected by the org filter. Organizations are migrated concurrently with requests paced to stay within
+// the Snyk API rate limit. Protected principals are skipped, and so are last admins when admin is migrated.
+// In dry run, the role updates are only logged and counted as migrated.
+func (s *Snyk) MigrateRole(ctx context.Context, m RoleMigration) ([]OrgMigration, error) {
+	if s.ReadOnly {
+		return nil, fmt.Errorf("snyk-connector: roles can't be migrated in %s mode", ReadOnly)
+	}
+
+	if s.groups.OrgTokenMode() {
+		return nil, fmt.Errorf("snyk-connector: migrating roles requires %s to be configured", GroupID)
+	}
+
+	if m.FromRole == m.ToRole {
+		return nil, fmt.Errorf("snyk-connector: the source and target roles are the same")
+	}
+
+	if m.Concurrency <= 0 {
+		m.Concurrency = DefaultOrgFetchConcurrency
+	}
+
+	done, err := loadMigrationProgress(m)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs := newOrgBuilder(s.groups, s.OrgFilter, nil, s.policy, s.verifier, s.journal, nil, false, false, 0)
+
+	var rv []OrgMigration
+	var jobs []migrationJob
+	found := false
+	for _, groupID := range s.groups.ids {
+		group := orgs.orgGroups[groupID]
+
+		roles, err := group.lookupRoles(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to list roles in group %s: %w", groupID, err)
+		}
+
+		// roles are defined per group, groups without the source role have nothing to migrate
+		from, err := findRoleByID(roles, m.FromRole, groupID)
+		if err != nil {
+			ctxzap.Extract(ctx).Warn("snyk-connector: role to migrate not found in group", zap.String("group_id", groupID), zap.String("role_id", m.FromRole))
+			continue
+		}
+
+		to, err := findRoleByID(roles, m.ToRole, groupID)
+		if err != nil {
+			return nil, err
+		}
+
+		found = true
+
+		groupOrgs, err := listAllOrgs(ctx, group.client)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: failed to list orgs in group %s: %w", groupID, err)
+		}
+
+		for _, org := range groupOrgs {
+			if !s.OrgFilter.Match(&org) {
+				continue
+			}
+
+			if summary, ok := done[org.ID]; ok {
+				summary.Resumed = true
+				rv = append(rv, summary)
+				continue
+			}
+
+			jobs = append(jobs, migrationJob{group: group, org: org, roles: roles, from: from, to: to})
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("snyk-connector: role %s not found in any of the synced groups", m.FromRole)
+	}
+
+	rv = append(rv, s.migrate(ctx, m, jobs)...)
+
+	slices.SortFunc(rv, func(a, b OrgMigration) int {
+		return strings.Compare(a.OrgName, b.OrgName)
+	})
+
+	return rv, ctx.Err()
+}
+
+// migrate migrates the organizations using a bounded pool of workers, recording the progress of each.
+func (s *Snyk) migrate(ctx context.Context, m RoleMigration, jobs []migrationJob) []OrgMigration {
+	l := ctxzap.Extract(ctx)
+	l.Info("snyk-connector: migrating org role", zap.String("from_role", m.FromRole), zap.String("to_role", m.ToRole), zap.Int("orgs", len(jobs)))
+
+	ticker := time.NewTicker(time.Minute / prefetchRequestsPerMinute)
+	defer ticker.Stop()
+
+	queue := make(chan migrationJob)
+	var results []OrgMigration
+	var resultsMtx sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < m.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for job := range queue {
+				summary := s.migrateOrg(ctx, ticker.C, &job)
+
+				resultsMtx.Lock()
+				results = append(results, summary)
+				// organizations with failures are retried when resumed
+				if summary.Failed == 0 && ctx.Err() == nil && !s.DryRun {
+					if err := appendMigrationProgress(m.ProgressPath, &summary); err != nil {
+						l.Error("snyk-connector: failed to record migration progress", zap.String("org_id", summary.OrgID), zap.Error(err))
+					}
+				}
+				resultsMtx.Unlock()
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+func (s *Snyk) migrateOrg(ctx context.Context, pace <-chan time.Time, job *migrationJob) OrgMigration {
+	l := ctxzap.Extract(ctx)
+
+	summary := OrgMigration{
+		FromRole: job.from.ID,
+		ToRole:   job.to.ID,
+		GroupID:  job.group.client.GroupID(),
+		OrgID:    job.org.ID,
+		OrgName:  job.org.Name,
+	}
+
+	fail := func(err error) {
+		summary.Failed++
+		summary.Errors = append(summary.Errors, err.Error())
+	}
+
+	wait := func() bool {
+		select {
+		case <-ctx.Done():
+			fail(ctx.Err())
+			return false
+		case <-pace:
+			return true
+		}
+	}
+
+	if !wait() {
+		return summary
+	}
+
+	// custom roles can share the slug the v1 API lists members with, so holders are matched by the public ID
+	// of their role. Admins of the group are only implicit members and aren't listed.
+	memberships, err := job.group.client.ListOrgMemberships(ctx, job.org.ID)
+	if err != nil {
+		fail(fmt.Errorf("snyk-connector: failed to list memberships in org %s: %w", job.org.ID, err))
+		return summary
+	}
+
+	// skipped members don't fail the organization, so it is recorded as completed and not retried
+	for i := range memberships {
+		ok, err := holdsRole(&memberships[i], job.from, job.roles)
+		if err != nil {
+			fail(fmt.Errorf("user %s: %w", memberships[i].User.ID, err))
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		member := &snyk.OrgUser{BaseUser: memberships[i].User, Role: job.from.Slug}
+
+		if reason := s.protectedReason(ctx, member, job.to.Slug); reason != "" {
+			l.Info("snyk-connector: skipping protected member", zap.String("org_id", job.org.ID), zap.String("user_id", member.ID))
+			summary.Skipped++
+			continue
+		}
+
+		// migrating away from admin demotes the member
+		if job.from.Slug == snyk.OrgAdminRole && job.to.Slug != snyk.OrgAdminRole {
+			if !wait() {
+				return summary
+			}
+
+			if err := s.policy.checkOrgAdmins(ctx, job.group.client, job.org.ID, member.ID); err != nil {
+				l.Info("snyk-connector: skipping member", zap.String("org_id", job.org.ID), zap.String("user_id", member.ID), zap.Error(err))
+				summary.Skipped++
+				continue
+			}
+		}
+
+		if !wait() {
+			return summary
+		}
+
+		if err := job.group.setRole(ctx, member.ID, job.org.ID, member.Role, job.to); err != nil {
+			fail(fmt.Errorf("user %s: %w", member.ID, err))
+			continue
+		}
+
+		summary.Migrated++
+	}
+
+	return summary
+}
+
+// holdsRole reports whether the membership holds the role. Memberships listed without the public ID of their
+// role are matched by the role name, failing when the name doesn't resolve to a single role of the group.
+func holdsRole(membership *snyk.OrgMembership, role *snyk.Role, roles []snyk.Role) (bool, error) {
+	if membership.Role.ID != "" {
+		return membership.Role.ID == role.ID, nil
+	}
+
+	var matches []string
+	for _, r := range roles {
+		if strings.EqualFold(r.Name, membership.Role.Name) {
+			matches = append(matches, r.ID)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return false, fmt.Errorf("snyk-connector: role '%s' of membership %s not found", membership.Role.Name, membership.ID)
+	case 1:
+		return matches[0] == role.ID, nil
+	default:
+		return false, fmt.Errorf("snyk-connector: role '%s' of membership %s is ambiguous, it matches roles %s", membership.Role.Name, membership.ID, strings.Join(matches, ", "))
+	}
+}
+
+func findRoleByID(roles []snyk.Role, roleID, groupID string) (*snyk.Role, error) {
+	i := slices.IndexFunc(roles, func(r snyk.Role) bool {
+		return r.ID == roleID
+	})
+	if i == -1 {
+		return nil, fmt.Errorf("snyk-connector: role %s not found in group %s", roleID, groupID)
+	}
+
+	return &roles[i], nil
+}
+
+// loadMigrationProgress returns organizations completed by previous runs of the same migration.
+func loadMigrationProgress(m RoleMigration) (map[string]OrgMigration, error) {
+	rv := make(map[string]OrgMigration)
+	if m.ProgressPath == "" {
+		return rv, nil
+	}
+
+	f, err := os.Open(m.ProgressPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return rv, nil
+		}
+
+		return nil, fmt.Errorf("snyk-connector: failed to open migration progress: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var summary OrgMigration
+		if err := json.Unmarshal(scanner.Bytes(), &summary); err != nil {
+			return nil, fmt.Errorf("snyk-connector: invalid migration progress on line %d: %w", line, err)
+		}
+
+		if summary.FromRole == m.FromRole && summary.ToRole == m.ToRole {
+			rv[summary.OrgID] = summary
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to read migration progress: %w", err)
+	}
+
+	return rv, nil
+}
+
+func appendMigrationProgress(path string, summary *OrgMigration) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+)
+
+func TestMigrateRoleMatchesByRoleID(t *testing.T) {
+	f := newFakeSnyk(t)
+	// both custom roles are listed with the "security" slug by the v1 members endpoint
+	f.roles = append(f.roles,
+		fakeRole{ID: "role-lead", Name: "Org Security Lead"},
+		fakeRole{ID: "role-viewer", Name: "Org Security Viewer"},
+	)
+	f.orgs["org-1"] = []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "user-1", RoleID: "role-lead"},
+		{ID: "user-2", RoleID: "role-viewer"},
+		{ID: "user-3", RoleID: "role-collaborator"},
+	}
+	f.orgs["org-2"] = []fakeMember{
+		{ID: "svc", RoleID: "role-lead"},
+		{ID: "user-4", RoleID: "role-lead"},
+	}
+
+	s := f.connector(nil)
+
+	results, err := s.MigrateRole(context.Background(), RoleMigration{FromRole: "role-lead", ToRole: "role-collaborator"})
+	if err != nil {
+		t.Fatalf("MigrateRole failed: %v", err)
+	}
+
+	want := map[string]struct{ migrated, skipped int }{
+		"org-1": {migrated: 1},
+		// the identity of the token is protected
+		"org-2": {migrated: 1, skipped: 1},
+	}
+
+	if len(results) != len(want) {
+		t.Fatalf("MigrateRole returned %+v, want summaries of %d orgs", results, len(want))
+	}
+
+	for _, r := range results {
+		w := want[r.OrgID]
+		if r.Migrated != w.migrated || r.Skipped != w.skipped || r.Failed != 0 {
+			t.Errorf("org %s migrated %d, skipped %d and failed %d (%v), want %d migrated and %d skipped",
+				r.OrgID, r.Migrated, r.Skipped, r.Failed, r.Errors, w.migrated, w.skipped)
+		}
+	}
+
+	roles := []struct {
+		orgID, userID, role string
+	}{
+		{"org-1", "admin-1", "role-admin"},
+		{"org-1", "user-1", "role-collaborator"},
+		{"org-1", "user-2", "role-viewer"},
+		{"org-1", "user-3", "role-collaborator"},
+		{"org-2", "svc", "role-lead"},
+		{"org-2", "user-4", "role-collaborator"},
+	}
+
+	for _, r := range roles {
+		if got := f.Role(r.orgID, r.userID); got != r.role {
+			t.Errorf("%s has role %q in %s after the migration, want %q", r.userID, got, r.orgID, r.role)
+		}
+	}
+}
+
+func TestHoldsRole(t *testing.T) {
+	roles := []snyk.Role{
+		{ID: "role-lead", Name: "Org Security Lead", Slug: "security"},
+		{ID: "role-viewer", Name: "Org Security Viewer", Slug: "security"},
+		{ID: "role-dup-1", Name: "Org Auditor", Slug: "auditor"},
+		{ID: "role-dup-2", Name: "org auditor", Slug: "auditor"},
+	}
+
+	membership := func(roleID, roleName string) *snyk.OrgMembership {
+		return &snyk.OrgMembership{BaseResource: snyk.BaseResource{ID: "membership"}, Role: snyk.Role{ID: roleID, Name: roleName}}
+	}
+
+	tests := []struct {
+		name       string
+		membership *snyk.OrgMembership
+		role       *snyk.Role
+		want       bool
+		wantErr    bool
+	}{
+		{name: "same role ID", membership: membership("role-lead", ""), role: &roles[0], want: true},
+		{name: "role sharing the slug", membership: membership("role-viewer", "Org Security Viewer"), role: &roles[0], want: false},
+		{name: "role ID wins over the name", membership: membership("role-viewer", "Org Security Lead"), role: &roles[0], want: false},
+		{name: "name without the role ID", membership: membership("", "Org Security Lead"), role: &roles[0], want: true},
+		{name: "other name without the role ID", membership: membership("", "Org Security Viewer"), role: &roles[0], want: false},
+		{name: "ambiguous name", membership: membership("", "Org Auditor"), role: &roles[2], wantErr: true},
+		{name: "unknown name", membership: membership("", "Org Unknown"), role: &roles[0], wantErr: true},
+		{name: "neither ID nor name", membership: membership("", ""), role: &roles[0], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := holdsRole(tt.membership, tt.role, roles)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("holdsRole = %v, want an error", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("holdsRole failed: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("holdsRole = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
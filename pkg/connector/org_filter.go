@@ -0,0 +1,265 @@
+package connector
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+)
+
+// OrgFilter selects organizations that are part of the sync.
+//
+// Organizations are selected when they match any of the IDs, slugs or names (if any are set),
+// match any of the include patterns (if any are set), match none of the exclude patterns
+// and match all the attributes. IDs, slugs, names and attribute values are glob patterns,
+// include and exclude patterns are regular expressions matched against the org name and slug.
+type OrgFilter struct {
+	IDs        []*glob
+	Slugs      []*glob
+	Names      []*glob
+	Include    []*regexp.Regexp
+	Exclude    []*regexp.Regexp
+	Attributes map[string]*glob
+}
+
+// glob is a shell-like pattern where '*' matches any sequence of characters (including '/'),
+// '?' matches any single character and '[...]' matches a character class.
+type glob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func compileGlob(pattern string) (*glob, error) {
+	var b strings.Builder
+	b.WriteString("^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := slices.Index(runes[i+1:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+
+			class := string(runes[i+1 : i+1+end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &glob{pattern: pattern, re: re}, nil
+}
+
+func compileGlobs(kind string, patterns []string) ([]*glob, error) {
+	var rv []*glob
+	for _, pattern := range patterns {
+		g, err := compileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: invalid org %s pattern '%s': %w", kind, pattern, err)
+		}
+
+		rv = append(rv, g)
+	}
+
+	return rv, nil
+}
+
+func (g *glob) Match(value string) bool {
+	return g.re.MatchString(value)
+}
+
+// NewOrgFilter validates and compiles the filter options.
+// Attributes are passed as "key=pattern" pairs.
+func NewOrgFilter(ids, slugs, names, include, exclude, attributes []string) (*OrgFilter, error) {
+	var err error
+	f := &OrgFilter{
+		Attributes: make(map[string]*glob, len(attributes)),
+	}
+
+	if f.IDs, err = compileGlobs("id", ids); err != nil {
+		return nil, err
+	}
+
+	if f.Slugs, err = compileGlobs("slug", slugs); err != nil {
+		return nil, err
+	}
+
+	if f.Names, err = compileGlobs("name", names); err != nil {
+		return nil, err
+	}
+
+	for _, expr := range include {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: invalid org include pattern '%s': %w", expr, err)
+		}
+
+		f.Include = append(f.Include, re)
+	}
+
+	for _, expr := range exclude {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: invalid org exclude pattern '%s': %w", expr, err)
+		}
+
+		f.Exclude = append(f.Exclude, re)
+	}
+
+	for _, attr := range attributes {
+		key, pattern, ok := strings.Cut(attr, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("snyk-connector: invalid org attribute filter '%s', expected key=pattern", attr)
+		}
+
+		if _, ok := orgAttributes(&snyk.Org{})[key]; !ok {
+			return nil, fmt.Errorf("snyk-connector: unknown org attribute '%s'", key)
+		}
+
+		g, err := compileGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: invalid org attribute pattern '%s': %w", pattern, err)
+		}
+
+		f.Attributes[key] = g
+	}
+
+	return f, nil
+}
+
+// IsEmpty reports whether the filter selects every organization.
+func (f *OrgFilter) IsEmpty() bool {
+	return f == nil || len(f.IDs)+len(f.Slugs)+len(f.Names)+len(f.Include)+len(f.Exclude)+len(f.Attributes) == 0
+}
+
+// Match reports whether the organization is selected by the filter.
+func (f *OrgFilter) Match(org *snyk.Org) bool {
+	if f.IsEmpty() {
+		return true
+	}
+
+	if len(f.IDs)+len(f.Slugs)+len(f.Names) > 0 {
+		if !matchAnyGlob(f.IDs, org.ID) && !matchAnyGlob(f.Slugs, org.Slug) && !matchAnyGlob(f.Names, org.Name) {
+			return false
+		}
+	}
+
+	if len(f.Include) > 0 && !matchAnyRegexp(f.Include, org) {
+		return false
+	}
+
+	if matchAnyRegexp(f.Exclude, org) {
+		return false
+	}
+
+	attrs := orgAttributes(org)
+	for key, g := range f.Attributes {
+		if !g.Match(attrs[key]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Unmatched returns descriptions of the filter rules that don't match any of the organizations.
+func (f *OrgFilter) Unmatched(orgs []snyk.Org) []string {
+	if f.IsEmpty() {
+		return nil
+	}
+
+	var rv []string
+	check := func(kind, rule string, match func(org *snyk.Org) bool) {
+		for i := range orgs {
+			if match(&orgs[i]) {
+				return
+			}
+		}
+
+		rv = append(rv, fmt.Sprintf("%s '%s'", kind, rule))
+	}
+
+	for _, g := range f.IDs {
+		check("id", g.pattern, func(org *snyk.Org) bool { return g.Match(org.ID) })
+	}
+
+	for _, g := range f.Slugs {
+		check("slug", g.pattern, func(org *snyk.Org) bool { return g.Match(org.Slug) })
+	}
+
+	for _, g := range f.Names {
+		check("name", g.pattern, func(org *snyk.Org) bool { return g.Match(org.Name) })
+	}
+
+	for _, re := range f.Include {
+		check("include pattern", re.String(), func(org *snyk.Org) bool { return matchAnyRegexp([]*regexp.Regexp{re}, org) })
+	}
+
+	for _, re := range f.Exclude {
+		check("exclude pattern", re.String(), func(org *snyk.Org) bool { return matchAnyRegexp([]*regexp.Regexp{re}, org) })
+	}
+
+	for key, g := range f.Attributes {
+		check("attribute", key+"="+g.pattern, func(org *snyk.Org) bool { return g.Match(orgAttributes(org)[key]) })
+	}
+
+	return rv
+}
+
+// orgAttributes returns attributes of the organization that can be used in filters.
+func orgAttributes(org *snyk.Org) map[string]string {
+	attrs := map[string]string{
+		"id":       org.ID,
+		"name":     org.Name,
+		"slug":     org.Slug,
+		"url":      org.URL,
+		"created":  org.Created,
+		"group_id": "",
+	}
+
+	if org.Group != nil {
+		attrs["group_id"] = org.Group.ID
+	}
+
+	return attrs
+}
+
+func matchAnyGlob(globs []*glob, value string) bool {
+	for _, g := range globs {
+		if g.Match(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchAnyRegexp(res []*regexp.Regexp, org *snyk.Org) bool {
+	for _, re := range res {
+		if re.MatchString(org.Name) || re.MatchString(org.Slug) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,62 @@
+package connector
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		value   string
+		match   bool
+	}{
+		{name: "literal", pattern: "team-a", value: "team-a", match: true},
+		{name: "literal mismatch", pattern: "team-a", value: "team-ab", match: false},
+		{name: "star matches any sequence", pattern: "team-*", value: "team-platform", match: true},
+		{name: "star matches empty sequence", pattern: "team-*", value: "team-", match: true},
+		{name: "star matches slash", pattern: "a*c", value: "a/b/c", match: true},
+		{name: "star is anchored", pattern: "team-*", value: "my-team-a", match: false},
+		{name: "question mark matches a single character", pattern: "org-?", value: "org-1", match: true},
+		{name: "question mark doesn't match two characters", pattern: "org-?", value: "org-12", match: false},
+		{name: "character class", pattern: "org-[abc]", value: "org-b", match: true},
+		{name: "character class mismatch", pattern: "org-[abc]", value: "org-d", match: false},
+		{name: "character range", pattern: "org-[0-9]", value: "org-7", match: true},
+		{name: "negated character class", pattern: "org-[!0-9]", value: "org-x", match: true},
+		{name: "negated character class mismatch", pattern: "org-[!0-9]", value: "org-7", match: false},
+		{name: "regexp metacharacters are literal", pattern: "a.b+c", value: "a.b+c", match: true},
+		{name: "dot is not a wildcard", pattern: "a.b", value: "axb", match: false},
+		{name: "empty pattern matches empty value", pattern: "", value: "", match: true},
+		{name: "empty pattern doesn't match a value", pattern: "", value: "a", match: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := compileGlob(tt.pattern)
+			if err != nil {
+				t.Fatalf("compileGlob(%q) failed: %v", tt.pattern, err)
+			}
+
+			if got := g.Match(tt.value); got != tt.match {
+				t.Errorf("compileGlob(%q).Match(%q) = %v, want %v", tt.pattern, tt.value, got, tt.match)
+			}
+		})
+	}
+}
+
+func TestCompileGlobInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+	}{
+		{name: "unterminated character class", pattern: "org-[abc"},
+		{name: "empty character class", pattern: "org-[]"},
+		{name: "empty negated character class", pattern: "org-[!]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileGlob(tt.pattern); err == nil {
+				t.Errorf("compileGlob(%q) succeeded, want an error", tt.pattern)
+			}
+		})
+	}
+}
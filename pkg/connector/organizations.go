@@ -14,6 +14,8 @@ import (
 	"github.com/conductorone/baton-snyk/pkg/snyk"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -23,8 +25,29 @@ const (
 )
 
 type orgBuilder struct {
-	client *snyk.Client
-	orgs   map[string]struct{}
+	groups     *groupClients
+	filter     *OrgFilter
+	principals *principalIndex
+	policy     *provisioningPolicy
+	orgGroups  map[string]*orgGroup
+}
+
+// orgGroup holds the group client and sync state shared by organizations of a single group.
+type orgGroup struct {
+	client      *snyk.Client
+	orgToken    bool
+	memberships *orgMemberships
+	prefetcher  *orgPrefetcher
+	snapshot    *syncSnapshot
+	verifier    *writeVerifier
+	journal     *provisioningJournal
+}
+
+// orgTokenRoles are the built-in org roles. Without access to the group, roles can't be listed,
+// so only these are synced and they are identified by their name instead of the public ID.
+var orgTokenRoles = []snyk.Role{
+	{ID: snyk.OrgAdminRole, Name: "Org Admin", Description: "Admin role in the organization", Slug: snyk.OrgAdminRole, Type: snyk.OrgRoleType},
+	{ID: snyk.OrgCollaboratorRole, Name: "Org Collaborator", Description: "Collaborator role in the organization", Slug: snyk.OrgCollaboratorRole, Type: snyk.OrgRoleType},
 }
 
 func (o *orgBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
@@ -58,26 +81,40 @@ func orgResource(ctx context.Context, org *snyk.Org, parentId *v2.ResourceId) (*
 // Users include a UserTrait because they are the 'shape' of a standard org.
 func (o *orgBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
 	if parentResourceID == nil {
+		if o.groups.OrgTokenMode() {
+			return o.listOrgTokenOrgs(ctx)
+		}
+
 		return nil, "", nil, nil
 	}
 
+	group, err := o.groupForParent(parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// a new listing of organizations starts a new sync - drop memberships from the previous one
+	if pToken.Token == "" {
+		group.Reset()
+	}
+
 	bag, page, err := parsePageToken(pToken.Token, &v2.ResourceId{ResourceType: orgResourceType.Id})
 	if err != nil {
 		return nil, "", nil, err
 	}
 
-	orgs, nextPageLink, err := o.client.ListOrgs(ctx, snyk.NewPaginationVars(page, ResourcesPageSize))
+	orgs, nextPageLink, err := group.client.ListOrgs(ctx, snyk.NewPaginationVars(page, ResourcesPageSize))
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list orgs: %w", err)
 	}
 
 	var rv []*v2.Resource
 	for _, org := range orgs {
-		if _, ok := o.orgs[org.ID]; !ok && len(o.orgs) > 0 {
+		orgCopy := org
+		if !o.filter.Match(&orgCopy) {
 			continue
 		}
 
-		orgCopy := org
 		resource, err := orgResource(ctx, &orgCopy, parentResourceID)
 		if err != nil {
 			return nil, "", nil, fmt.Errorf("snyk-connector: failed to create org resource: %w", err)
@@ -98,6 +135,29 @@ func (o *orgBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId,
 	return rv, nextToken, nil, nil
 }
 
+// listOrgTokenOrgs returns organizations accessible with the token as top level resources.
+func (o *orgBuilder) listOrgTokenOrgs(ctx context.Context) ([]*v2.Resource, string, annotations.Annotations, error) {
+	// organizations are the root of the sync without a group - drop principals indexed by the previous one
+	o.principals.Reset()
+
+	orgs, err := listOrgTokenOrgs(ctx, o.groups.orgClient, o.filter)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Resource
+	for i := range orgs {
+		resource, err := orgResource(ctx, &orgs[i], nil)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("snyk-connector: failed to create org resource: %w", err)
+		}
+
+		rv = append(rv, resource)
+	}
+
+	return rv, "", nil, nil
+}
+
 // Entitlements returns slice of membership and permission entitlements for the org.
 func (o *orgBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
 	var rv []*v2.Entitlement
@@ -111,8 +171,13 @@ func (o *orgBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _
 
 	rv = append(rv, ent.NewAssignmentEntitlement(resource, OrgMemberEntitlement, assignmentOptions...))
 
+	group, err := o.groupForOrg(ctx, resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
 	// permission entitlements - could contain custom roles
-	roles, err := o.client.ListOrgRoles(ctx)
+	roles, err := group.listRoles(ctx)
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("failed to list roles in organization: %w", err)
 	}
@@ -135,21 +200,29 @@ func (o *orgBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *
 	l := ctxzap.Extract(ctx)
 	var rv []*v2.Grant
 
-	members, err := o.client.ListUsersInOrg(ctx, resource.Id.Resource)
+	group, err := o.groupForOrg(ctx, resource)
 	if err != nil {
-		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list users in org: %w", err)
+		return nil, "", nil, err
 	}
 
 	// permission grants - require finding role public id to match with entitlement
-	roles, err := o.client.ListOrgRoles(ctx)
+	roles, err := group.listRoles(ctx)
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list roles in org: %w", err)
 	}
 
-	for _, member := range members {
+	if err := o.principals.Load(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	err = group.forEachMember(ctx, resource.Id.Resource, func(member *snyk.OrgUser) error {
+		if o.principals.IsExcluded(member.ID) {
+			return nil
+		}
+
 		userId, err := rs.NewResourceID(userResourceType, member.ID)
 		if err != nil {
-			return nil, "", nil, fmt.Errorf("snyk-connector: failed to create user resource id: %w", err)
+			return fmt.Errorf("snyk-connector: failed to create user resource id: %w", err)
 		}
 
 		// membership grants
@@ -162,16 +235,225 @@ func (o *orgBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *
 
 		if rI == -1 {
 			l.Warn("snyk-connector: role not found", zap.String("role", member.Role))
-			continue
+			return nil
 		}
 
 		rv = append(rv, grant.NewGrant(resource, roles[rI].ID, userId))
+		return nil
+	})
+	if err != nil {
+		return nil, "", nil, err
 	}
 
 	return rv, "", nil, nil
 }
 
-func (o *orgBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+// forEachMember calls fn for every member of the organization, preferring memberships resolved
+// from the group members response or prefetched data, and streaming them from the API otherwise.
+func (g *orgGroup) forEachMember(ctx context.Context, orgID string, fn func(member *snyk.OrgUser) error) error {
+	members, ok, err := g.cachedMembers(ctx, orgID)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		for i := range members {
+			if err := fn(&members[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err = g.client.ForEachUserInOrg(ctx, orgID, func(member *snyk.OrgUser) error {
+		if g.snapshot != nil {
+			members = append(members, *member)
+		}
+
+		return fn(member)
+	})
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to list users in org: %w", err)
+	}
+
+	return g.snapshot.Record(ctx, g.client.GroupID(), orgID, members)
+}
+
+// cachedMembers returns members of the organization already known from the group members response,
+// the snapshot of the previous sync or prefetching.
+func (g *orgGroup) cachedMembers(ctx context.Context, orgID string) ([]snyk.OrgUser, bool, error) {
+	if g.memberships != nil {
+		members, complete, err := g.memberships.Members(ctx, orgID)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if complete {
+			return members, true, g.snapshot.Record(ctx, g.client.GroupID(), orgID, members)
+		}
+
+		ctxzap.Extract(ctx).Debug("snyk-connector: incomplete group memberships, listing org members", zap.String("org_id", orgID))
+	}
+
+	members, ok, err := g.snapshot.Members(ctx, g.client.GroupID(), orgID)
+	if err != nil || ok {
+		return members, ok, err
+	}
+
+	if g.prefetcher != nil {
+		members, ok, err := g.prefetcher.Members(ctx, orgID)
+		if err != nil || !ok {
+			return members, ok, err
+		}
+
+		return members, true, g.snapshot.Record(ctx, g.client.GroupID(), orgID, members)
+	}
+
+	return nil, false, nil
+}
+
+// listRoles returns org roles, served from the prefetched data when available.
+func (g *orgGroup) listRoles(ctx context.Context) ([]snyk.Role, error) {
+	if g.prefetcher != nil {
+		return g.prefetcher.Roles(ctx)
+	}
+
+	return g.lookupRoles(ctx)
+}
+
+// lookupRoles returns current org roles from the API.
+func (g *orgGroup) lookupRoles(ctx context.Context) ([]snyk.Role, error) {
+	if g.orgToken {
+		return orgTokenRoles, nil
+	}
+
+	return g.client.ListOrgRoles(ctx)
+}
+
+// setRole replaces the previous role (slug) of the organization member with the role.
+func (g *orgGroup) setRole(ctx context.Context, userID, orgID, previous string, role *snyk.Role) error {
+	var err error
+	if g.orgToken {
+		err = g.client.UpdateOrgMemberRole(ctx, userID, orgID, role.ID)
+	} else {
+		err = g.client.UpdateOrgRole(ctx, userID, orgID, role.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to update user role in org: %w", err)
+	}
+
+	err = g.record(ctx, JournalUpdate, orgID, userID, previous, role.Slug)
+	if err != nil {
+		return err
+	}
+
+	return g.verifier.orgMember(ctx, g, orgID, userID, role)
+}
+
+// findMember returns the organization member, or nil if the user isn't a member.
+// Members are read directly from the API, so changes made since the sync are accounted for.
+func (g *orgGroup) findMember(ctx context.Context, orgID, userID string) (*snyk.OrgUser, error) {
+	var rv *snyk.OrgUser
+	err := g.client.ForEachUserInOrg(ctx, orgID, func(member *snyk.OrgUser) error {
+		if member.ID == userID {
+			rv = member
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to list users in org: %w", err)
+	}
+
+	return rv, nil
+}
+
+// addMember adds the user to the organization with the role.
+// Built-in roles are assigned through the v1 API, custom roles by creating the membership with the role right away.
+func (g *orgGroup) addMember(ctx context.Context, userID, orgID string, role *snyk.Role) error {
+	if g.orgToken {
+		return status.Error(codes.Unimplemented, "snyk-connector: adding users to organizations requires group-id to be configured")
+	}
+
+	var err error
+	if role.Slug == snyk.OrgAdminRole || role.Slug == snyk.OrgCollaboratorRole {
+		err = g.client.AddOrgMember(ctx, userID, orgID, role.Slug)
+	} else {
+		err = g.client.AddOrgMembership(ctx, userID, orgID, role.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to add user to org: %w", err)
+	}
+
+	err = g.record(ctx, JournalAdd, orgID, userID, "", role.Slug)
+	if err != nil {
+		return err
+	}
+
+	return g.verifier.orgMember(ctx, g, orgID, userID, role)
+}
+
+// removeMember removes the user with the previous role (slug) from the organization.
+func (g *orgGroup) removeMember(ctx context.Context, userID, orgID, previous string) error {
+	err := g.client.RemoveOrgMember(ctx, userID, orgID)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to remove user from org: %w", err)
+	}
+
+	err = g.record(ctx, JournalRemove, orgID, userID, previous, "")
+	if err != nil {
+		return err
+	}
+
+	return g.verifier.orgMember(ctx, g, orgID, userID, nil)
+}
+
+func (g *orgGroup) record(ctx context.Context, action, orgID, userID, previous, role string) error {
+	return g.journal.record(ctx, JournalEntry{
+		Scope:        JournalScopeOrg,
+		Action:       action,
+		GroupID:      g.client.GroupID(),
+		OrgID:        orgID,
+		UserID:       userID,
+		PreviousRole: previous,
+		NewRole:      role,
+	})
+}
+
+// Reset drops memberships and prefetched data of the previous sync.
+func (g *orgGroup) Reset() {
+	if g.memberships != nil {
+		g.memberships.Reset()
+	}
+
+	if g.prefetcher != nil {
+		g.prefetcher.Reset()
+	}
+}
+
+func (o *orgBuilder) groupForParent(parentResourceID *v2.ResourceId) (*orgGroup, error) {
+	client, err := o.groups.ForParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.orgGroups[client.GroupID()], nil
+}
+
+func (o *orgBuilder) groupForOrg(ctx context.Context, org *v2.Resource) (*orgGroup, error) {
+	client, err := o.groups.ForOrg(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.orgGroups[client.GroupID()], nil
+}
+
+// Grant returns the grants the user holds in the organization afterwards: the membership and the role. Snyk allows
+// a single role per organization, so the grant of the role that was replaced is reported in the metadata of the
+// role grant.
+func (o *orgBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
 	l := ctxzap.Extract(ctx)
 
 	if principal.Id.ResourceType != userResourceType.Id {
@@ -181,24 +463,124 @@ func (o *orgBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlem
 			zap.String("principal_type", principal.Id.ResourceType),
 		)
 
-		return nil, fmt.Errorf("snyk-connector: only users can be granted organization entitlements")
+		return nil, nil, status.Error(codes.InvalidArgument, "snyk-connector: only users can be granted organization entitlements")
+	}
+
+	group, err := o.groupForOrg(ctx, entitlement.Resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	orgID := entitlement.Resource.Id.Resource
+	userID := principal.Id.Resource
+
+	roles, err := group.lookupRoles(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("snyk-connector: failed to list roles in org: %w", err)
+	}
+
+	// detect existing membership first, so users are never added with an unintended role
+	member, err := group.findMember(ctx, orgID, userID)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	if entitlement.Slug == OrgMemberEntitlement {
-		err := o.client.AddOrgMember(ctx, principal.Id.Resource, entitlement.Resource.Id.Resource)
+		if member != nil {
+			current, _ := findOrgRole(roles, member.Role)
+			annos, err := alreadyGranted(ctx, principal, entitlement)
+			return orgGrants(entitlement.Resource, principal, current, nil), annos, err
+		}
+
+		role, err := o.policy.orgMember(roles)
 		if err != nil {
-			return nil, fmt.Errorf("snyk-connector: failed to add user to org: %w", err)
+			return nil, nil, err
 		}
 
-		return nil, nil
-	} else {
-		err := o.client.UpdateOrgRole(ctx, principal.Id.Resource, entitlement.Resource.Id.Resource, entitlement.Slug)
+		err = group.addMember(ctx, userID, orgID, role)
 		if err != nil {
-			return nil, fmt.Errorf("snyk-connector: failed to update user role in org: %w", err)
+			return nil, nil, err
 		}
+
+		return orgGrants(entitlement.Resource, principal, role, nil), nil, nil
 	}
 
-	return nil, nil
+	rI := slices.IndexFunc(roles, func(r snyk.Role) bool {
+		return r.ID == entitlement.Slug
+	})
+	if rI == -1 {
+		return nil, nil, status.Errorf(codes.NotFound, "snyk-connector: role %s not found", entitlement.Slug)
+	}
+
+	role := &roles[rI]
+
+	if member == nil {
+		// add non-members directly with the granted role
+		err = group.addMember(ctx, userID, orgID, role)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return orgGrants(entitlement.Resource, principal, role, nil), nil, nil
+	}
+
+	if member.Role == role.Slug {
+		annos, err := alreadyGranted(ctx, principal, entitlement)
+		return orgGrants(entitlement.Resource, principal, role, nil), annos, err
+	}
+
+	// assigning any other role than admin could demote the member, promotions to admin are always allowed
+	if role.Slug != snyk.OrgAdminRole {
+		err = o.policy.checkProtected(ctx, userID, func(ctx context.Context) (string, error) { return member.Email, nil })
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = o.policy.checkOrgAdmins(ctx, group.client, orgID, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = group.setRole(ctx, userID, orgID, member.Role, role)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	superseded, ok := findOrgRole(roles, member.Role)
+	if !ok {
+		l.Warn("snyk-connector: replaced role not found", zap.String("role", member.Role))
+	} else {
+		l.Info(
+			"snyk-connector: org role replaced",
+			zap.String("org_id", orgID),
+			zap.String("user_id", userID),
+			zap.String("superseded_role_id", superseded.ID),
+			zap.String("role_id", role.ID),
+		)
+	}
+
+	return orgGrants(entitlement.Resource, principal, role, superseded), nil, nil
+}
+
+// orgGrants returns the membership and role grants of a user in the organization. The grant of the role
+// replaced by the role is added to the role grant metadata, so it can be removed without a full sync.
+func orgGrants(org *v2.Resource, principal *v2.Resource, role, superseded *snyk.Role) []*v2.Grant {
+	rv := []*v2.Grant{grant.NewGrant(org, OrgMemberEntitlement, principal.Id)}
+	if role == nil {
+		return rv
+	}
+
+	var opts []grant.GrantOption
+	if superseded != nil && superseded.ID != role.ID {
+		opts = append(opts, grant.WithGrantMetadata(map[string]interface{}{
+			"superseded_grant_id":       grant.NewGrant(org, superseded.ID, principal.Id).Id,
+			"superseded_entitlement_id": ent.NewEntitlementID(org, superseded.ID),
+			"superseded_role":           superseded.Name,
+		}))
+	}
+
+	return append(rv, grant.NewGrant(org, role.ID, principal.Id, opts...))
 }
 
 func (o *orgBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
@@ -214,64 +596,152 @@ func (o *orgBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.A
 			zap.String("principal_type", principal.Id.ResourceType),
 		)
 
-		return nil, fmt.Errorf("snyk-connector: only users can have organization entitlements revoked")
+		return nil, status.Error(codes.InvalidArgument, "snyk-connector: only users can have organization entitlements revoked")
 	}
 
-	if entitlement.Slug == OrgMemberEntitlement {
-		err := o.client.RemoveOrgMember(ctx, principal.Id.Resource, entitlement.Resource.Id.Resource)
-		if err != nil {
-			return nil, fmt.Errorf("snyk-connector: failed to remove user from org: %w", err)
-		}
-	} else {
-		rolePublicID := entitlement.Slug
-		roles, err := o.client.ListOrgRoles(ctx)
+	group, err := o.groupForOrg(ctx, entitlement.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	orgID := entitlement.Resource.Id.Resource
+	userID := principal.Id.Resource
+
+	member, err := group.findMember(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if member == nil {
+		return alreadyRevoked(ctx, principal, entitlement)
+	}
+
+	var roles []snyk.Role
+	if entitlement.Slug != OrgMemberEntitlement {
+		roles, err = group.lookupRoles(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("snyk-connector: failed to list roles in org: %w", err)
 		}
 
 		// check if the role is a valid role
-		rI := slices.IndexFunc(roles, func(r snyk.Role) bool {
-			return r.ID == rolePublicID
-		})
-		if rI == -1 {
-			return nil, fmt.Errorf("snyk-connector: role %s not found", rolePublicID)
+		role, ok := findOrgRole(roles, entitlement.Slug)
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "snyk-connector: role %s not found", entitlement.Slug)
 		}
 
-		// find minimal default role collaborator
-		cI := slices.IndexFunc(roles, func(r snyk.Role) bool {
-			return r.Slug == OrgCollaboratorEntitlement
-		})
-		if cI == -1 {
-			return nil, fmt.Errorf("snyk-connector: minimal default role %s not found", OrgCollaboratorEntitlement)
+		if member.Role != role.Slug {
+			return alreadyRevoked(ctx, principal, entitlement)
 		}
+	}
 
-		collaborator := roles[cI]
-		if rolePublicID == collaborator.ID {
-			// if we're revoking collaborator role - remove from org
-			err = o.client.RemoveOrgMember(ctx, principal.Id.Resource, entitlement.Resource.Id.Resource)
-			if err != nil {
-				return nil, fmt.Errorf("snyk-connector: failed to remove user from org: %w", err)
-			}
-		} else {
-			// if we're revoking admin or other role - rollback to minimal role collaborator
-			err = o.client.UpdateOrgRole(ctx, principal.Id.Resource, entitlement.Resource.Id.Resource, collaborator.ID)
-			if err != nil {
-				return nil, fmt.Errorf("snyk-connector: failed to update user role in org: %w", err)
-			}
+	err = o.policy.checkProtected(ctx, userID, func(ctx context.Context) (string, error) { return member.Email, nil })
+	if err != nil {
+		return nil, err
+	}
+
+	// every revoke either demotes or removes the member
+	err = o.policy.checkOrgAdmins(ctx, group.client, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var demote *snyk.Role
+	if entitlement.Slug != OrgMemberEntitlement && !o.policy.orgRevokeRemove {
+		demote, err = o.policy.orgDemote(roles)
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	if demote == nil || entitlement.Slug == demote.ID {
+		// if we're revoking the membership or the role members are demoted to, or the policy removes on revoke - remove from org
+		err = group.removeMember(ctx, userID, orgID, member.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	// if we're revoking admin or other role - rollback to the configured demote role
+	l.Info(
+		"snyk-connector: demoting user to the configured org role",
+		zap.String("org_id", orgID),
+		zap.String("user_id", userID),
+		zap.String("revoked_role_id", entitlement.Slug),
+		zap.String("role_id", demote.ID),
+		zap.String("role", demote.Name),
+	)
+
+	err = group.setRole(ctx, userID, orgID, member.Role, demote)
+	if err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
-func newOrgBuilder(client *snyk.Client, orgs []string) *orgBuilder {
-	orgMap := make(map[string]struct{}, len(orgs))
-	for _, org := range orgs {
-		orgMap[org] = struct{}{}
+func newOrgBuilder(
+	groups *groupClients,
+	filter *OrgFilter,
+	principals *principalIndex,
+	policy *provisioningPolicy,
+	verifier *writeVerifier,
+	journal *provisioningJournal,
+	snapshot *syncSnapshot,
+	optimizedSync, prefetch bool,
+	concurrency int,
+) *orgBuilder {
+	o := &orgBuilder{
+		groups:     groups,
+		filter:     filter,
+		principals: principals,
+		policy:     policy,
+		orgGroups:  make(map[string]*orgGroup, len(groups.ids)),
+	}
+
+	for _, groupID := range groups.ids {
+		group := &orgGroup{
+			client:   groups.clients[groupID],
+			snapshot: snapshot,
+			verifier: verifier,
+			journal:  journal,
+		}
+
+		if optimizedSync {
+			group.memberships = newOrgMemberships(group.client)
+		}
+
+		if prefetch {
+			group.prefetcher = newOrgPrefetcher(group.client, concurrency)
+			group.prefetcher.include = filter.Match
+
+			// only prefetch organizations that can't be resolved from the group members response or the snapshot
+			if group.memberships != nil || group.snapshot != nil {
+				group.prefetcher.skip = func(ctx context.Context, orgID string) (bool, error) {
+					if group.memberships != nil {
+						_, complete, err := group.memberships.Members(ctx, orgID)
+						if err != nil || complete {
+							return complete, err
+						}
+					}
+
+					return group.snapshot.Unchanged(ctx, groupID, orgID)
+				}
+			}
+		}
+
+		o.orgGroups[groupID] = group
 	}
 
-	return &orgBuilder{
-		client: client,
-		orgs:   orgMap,
+	if groups.OrgTokenMode() {
+		o.orgGroups[""] = &orgGroup{
+			client:   groups.orgClient,
+			orgToken: true,
+			verifier: verifier,
+			journal:  journal,
+		}
 	}
+
+	return o
 }
@@ -0,0 +1,293 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// provisioningPolicy holds the safety checks applied before grants and revokes change memberships.
+type provisioningPolicy struct {
+	// allowLastAdminRemoval disables the check keeping at least one admin in every organization and tenant.
+	allowLastAdminRemoval bool
+	// protected are IDs and lowercased emails of principals that can't be demoted or removed.
+	protected map[string]struct{}
+	// client resolves the identity of the token, which is always protected.
+	client *snyk.Client
+	// orgMemberRole is the role of users added to organizations, orgDemoteRole the role members are demoted to
+	// when a role is revoked, unless orgRevokeRemove removes them from the organization instead.
+	orgMemberRole   string
+	orgDemoteRole   string
+	orgRevokeRemove bool
+	// tenantMemberRole is the role (ID or name) of users added to the tenant and members are demoted to,
+	// tenantAdminRoles the roles (IDs or names) counted as admins of the tenant.
+	tenantMemberRole string
+	tenantAdminRoles []string
+
+	mtx    sync.Mutex
+	selfID string
+}
+
+func newProvisioningPolicy(client *snyk.Client, cfg *Config) (*provisioningPolicy, error) {
+	p := &provisioningPolicy{
+		allowLastAdminRemoval: cfg.AllowLastAdminRemoval,
+		protected:             make(map[string]struct{}, len(cfg.ProtectedPrincipals)),
+		client:                client,
+		orgMemberRole:         cfg.OrgMemberRole,
+		orgDemoteRole:         cfg.OrgDemoteRole,
+		tenantMemberRole:      cfg.TenantMemberRole,
+		tenantAdminRoles:      cfg.TenantAdminRoles,
+	}
+
+	for _, principal := range cfg.ProtectedPrincipals {
+		p.protected[strings.ToLower(principal)] = struct{}{}
+	}
+
+	if p.orgMemberRole == "" {
+		p.orgMemberRole = snyk.OrgCollaboratorRole
+	}
+
+	if p.orgDemoteRole == "" {
+		p.orgDemoteRole = p.orgMemberRole
+	}
+
+	if p.tenantMemberRole == "" {
+		p.tenantMemberRole = DefaultTenantMemberRole
+	}
+
+	if len(p.tenantAdminRoles) == 0 {
+		p.tenantAdminRoles = []string{DefaultTenantAdminRole}
+	}
+
+	switch cfg.OrgRevokeBehavior {
+	case "", OrgRevokeDemote:
+	case OrgRevokeRemove:
+		p.orgRevokeRemove = true
+	default:
+		return nil, fmt.Errorf("snyk-connector: invalid %s '%s', expected %s or %s", OrgRevokeBehavior, cfg.OrgRevokeBehavior, OrgRevokeDemote, OrgRevokeRemove)
+	}
+
+	return p, nil
+}
+
+// findOrgRole returns the org role matching the public ID, slug or name.
+func findOrgRole(roles []snyk.Role, role string) (*snyk.Role, bool) {
+	for i, r := range roles {
+		if r.ID == role || strings.EqualFold(r.Slug, role) || strings.EqualFold(r.Name, role) {
+			return &roles[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// orgMember returns the role of users added to organizations.
+func (p *provisioningPolicy) orgMember(roles []snyk.Role) (*snyk.Role, error) {
+	role, ok := findOrgRole(roles, p.orgMemberRole)
+	if !ok {
+		return nil, fmt.Errorf("snyk-connector: org member role %s not found", p.orgMemberRole)
+	}
+
+	return role, nil
+}
+
+// orgDemote returns the role members are demoted to when a role is revoked.
+func (p *provisioningPolicy) orgDemote(roles []snyk.Role) (*snyk.Role, error) {
+	role, ok := findOrgRole(roles, p.orgDemoteRole)
+	if !ok {
+		return nil, fmt.Errorf("snyk-connector: org demote role %s not found", p.orgDemoteRole)
+	}
+
+	return role, nil
+}
+
+// validateOrgRoles checks the configured org roles exist.
+func (p *provisioningPolicy) validateOrgRoles(roles []snyk.Role) error {
+	if _, err := p.orgMember(roles); err != nil {
+		return err
+	}
+
+	if p.orgRevokeRemove {
+		return nil
+	}
+
+	_, err := p.orgDemote(roles)
+	return err
+}
+
+// self returns ID of the user or service account the token represents.
+func (p *provisioningPolicy) self(ctx context.Context) (string, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.selfID == "" {
+		user, err := p.client.GetCurrentUser(ctx)
+		if err != nil {
+			return "", fmt.Errorf("snyk-connector: failed to resolve identity of the token: %w", err)
+		}
+
+		p.selfID = user.ID
+	}
+
+	return p.selfID, nil
+}
+
+// checkProtected refuses to change the user if it is protected or it is the identity of the token,
+// so the connector can't lock itself or break-glass accounts out. The email is only needed to match
+// protected emails and is looked up lazily.
+func (p *provisioningPolicy) checkProtected(ctx context.Context, userID string, email func(ctx context.Context) (string, error)) error {
+	selfID, err := p.self(ctx)
+	if err != nil {
+		return err
+	}
+
+	if userID == selfID {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"snyk-connector: user %s is the identity of the connector's API token, refusing to demote or remove it",
+			userID,
+		)
+	}
+
+	if _, ok := p.protected[strings.ToLower(userID)]; ok {
+		return status.Errorf(codes.FailedPrecondition, "snyk-connector: user %s is protected (%s), refusing to demote or remove it", userID, ProtectedPrincipals)
+	}
+
+	if len(p.protected) == 0 || email == nil {
+		return nil
+	}
+
+	e, err := email(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := p.protected[strings.ToLower(e)]; ok && e != "" {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"snyk-connector: user %s (%s) is protected (%s), refusing to demote or remove it",
+			userID, e, ProtectedPrincipals,
+		)
+	}
+
+	return nil
+}
+
+// checkOrgAdmins refuses to demote or remove the user if it is the last admin of the organization.
+// Members are read directly from the API, so admins changed earlier in the same run are accounted for.
+func (p *provisioningPolicy) checkOrgAdmins(ctx context.Context, client *snyk.Client, orgID, userID string) error {
+	if p.allowLastAdminRemoval {
+		return nil
+	}
+
+	admins := 0
+	isAdmin := false
+	err := client.ForEachUserInOrg(ctx, orgID, func(member *snyk.OrgUser) error {
+		if member.Role != snyk.OrgAdminRole {
+			return nil
+		}
+
+		admins++
+		if member.ID == userID {
+			isAdmin = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to count admins of org %s: %w", orgID, err)
+	}
+
+	if isAdmin && admins <= 1 {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"snyk-connector: user %s is the last admin of org %s, refusing to demote or remove it (set %s to override)",
+			userID, orgID, AllowLastAdminRemoval,
+		)
+	}
+
+	return nil
+}
+
+// checkTenantAdmins refuses to demote or remove the user if it is the last admin of the tenant.
+func (p *provisioningPolicy) checkTenantAdmins(tenantID, userID string, memberships []snyk.TenantMembership) error {
+	if p.allowLastAdminRemoval {
+		return nil
+	}
+
+	admins := 0
+	isAdmin := false
+	for _, m := range memberships {
+		if !p.isTenantAdmin(&m.Role) {
+			continue
+		}
+
+		admins++
+		if m.User.ID == userID {
+			isAdmin = true
+		}
+	}
+
+	if isAdmin && admins <= 1 {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"snyk-connector: user %s is the last admin of tenant %s, refusing to demote or remove it (set %s to override)",
+			userID, tenantID, AllowLastAdminRemoval,
+		)
+	}
+
+	return nil
+}
+
+// findTenantRole returns the tenant role matching the ID or name.
+func findTenantRole(roles []snyk.TenantRole, role string) (*snyk.TenantRole, bool) {
+	for i, r := range roles {
+		if r.ID == role || strings.EqualFold(r.Name, role) {
+			return &roles[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// tenantMember returns the role of users added to the tenant, which members are demoted to.
+func (p *provisioningPolicy) tenantMember(roles []snyk.TenantRole) (*snyk.TenantRole, error) {
+	role, ok := findTenantRole(roles, p.tenantMemberRole)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "snyk-connector: tenant member role %s not found", p.tenantMemberRole)
+	}
+
+	return role, nil
+}
+
+// isTenantAdmin reports whether the tenant role is one of the admin roles. Memberships may only
+// carry the ID of their role, so roles are matched by their ID or name.
+func (p *provisioningPolicy) isTenantAdmin(role *snyk.TenantRole) bool {
+	for _, admin := range p.tenantAdminRoles {
+		if role.ID == admin || (role.Name != "" && strings.EqualFold(role.Name, admin)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateTenantRoles checks the configured tenant roles exist in the tenant.
+func (p *provisioningPolicy) validateTenantRoles(roles []snyk.TenantRole) error {
+	if _, err := p.tenantMember(roles); err != nil {
+		return err
+	}
+
+	for _, admin := range p.tenantAdminRoles {
+		if _, ok := findTenantRole(roles, admin); !ok {
+			return fmt.Errorf("snyk-connector: tenant admin role %s not found", admin)
+		}
+	}
+
+	return nil
+}
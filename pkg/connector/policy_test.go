@@ -0,0 +1,161 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// policyTest is a grant or revoke of an org entitlement, and the error code it must be refused with.
+type policyTest struct {
+	name    string
+	members []fakeMember
+	grant   bool
+	slug    string
+	userID  string
+	// code is the code of the refusal, OK when the change is allowed
+	code codes.Code
+	// role is the public ID of the role the user has afterwards, empty if it isn't a member
+	role string
+}
+
+func runPolicyTests(t *testing.T, cfg func() *Config, tests []policyTest) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			f := newFakeSnyk(t)
+			// the fake changes members in place, every test starts from the same ones
+			f.orgs["org-1"] = append([]fakeMember(nil), tt.members...)
+
+			orgs := testOrgBuilder(f.connector(cfg()))
+			g := testOrgGrant("org-1", tt.slug, tt.userID)
+
+			var err error
+			if tt.grant {
+				_, _, err = orgs.Grant(ctx, g.Principal, g.Entitlement)
+			} else {
+				_, err = orgs.Revoke(ctx, g)
+			}
+
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("got %v (%v), want %v", code, err, tt.code)
+			}
+
+			if tt.code != codes.OK && len(f.Writes()) > 0 {
+				t.Errorf("refused change sent %v", f.Writes())
+			}
+
+			if role := f.Role("org-1", tt.userID); role != tt.role {
+				t.Errorf("%s has role %q afterwards, want %q", tt.userID, role, tt.role)
+			}
+		})
+	}
+}
+
+func TestOrgProtectedPrincipals(t *testing.T) {
+	members := []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "svc", RoleID: "role-admin"},
+		{ID: "vip-1", RoleID: "role-admin"},
+		{ID: "vip-2", Email: "VIP@example.com", RoleID: "role-admin"},
+		{ID: "vip-3", RoleID: "role-collaborator"},
+	}
+
+	cfg := func() *Config {
+		return &Config{ProtectedPrincipals: []string{"vip-1", "vip-3", "vip@example.com"}}
+	}
+
+	runPolicyTests(t, cfg, []policyTest{
+		{
+			name: "revoking the admin role of the token identity", members: members,
+			slug: "role-admin", userID: "svc", code: codes.FailedPrecondition, role: "role-admin",
+		},
+		{
+			name: "removing the token identity", members: members,
+			slug: OrgMemberEntitlement, userID: "svc", code: codes.FailedPrecondition, role: "role-admin",
+		},
+		{
+			name: "revoking the admin role of a principal protected by ID", members: members,
+			slug: "role-admin", userID: "vip-1", code: codes.FailedPrecondition, role: "role-admin",
+		},
+		{
+			name: "removing a principal protected by email", members: members,
+			slug: OrgMemberEntitlement, userID: "vip-2", code: codes.FailedPrecondition, role: "role-admin",
+		},
+		{
+			name: "demoting a protected admin by a grant", members: members,
+			grant: true, slug: "role-collaborator", userID: "vip-1", code: codes.FailedPrecondition, role: "role-admin",
+		},
+		{
+			name: "promoting a protected principal to admin", members: members,
+			grant: true, slug: "role-admin", userID: "vip-3", role: "role-admin",
+		},
+		{
+			name: "revoking the admin role of an unprotected admin", members: members,
+			slug: "role-admin", userID: "admin-1", role: "role-collaborator",
+		},
+	})
+}
+
+func TestOrgProtectedTokenIdentityPromotion(t *testing.T) {
+	members := []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "svc", RoleID: "role-collaborator"},
+	}
+
+	runPolicyTests(t, func() *Config { return nil }, []policyTest{
+		{
+			name: "promoting the token identity to admin", members: members,
+			grant: true, slug: "role-admin", userID: "svc", role: "role-admin",
+		},
+		{
+			name: "removing the token identity", members: members,
+			slug: OrgMemberEntitlement, userID: "svc", code: codes.FailedPrecondition, role: "role-collaborator",
+		},
+	})
+}
+
+func TestOrgLastAdmin(t *testing.T) {
+	lastAdmin := []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "user-1", RoleID: "role-collaborator"},
+	}
+
+	admins := []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "admin-2", RoleID: "role-admin"},
+	}
+
+	runPolicyTests(t, func() *Config { return nil }, []policyTest{
+		{
+			name: "revoking the admin role of the last admin", members: lastAdmin,
+			slug: "role-admin", userID: "admin-1", code: codes.FailedPrecondition, role: "role-admin",
+		},
+		{
+			name: "removing the last admin", members: lastAdmin,
+			slug: OrgMemberEntitlement, userID: "admin-1", code: codes.FailedPrecondition, role: "role-admin",
+		},
+		{
+			name: "demoting the last admin by a grant", members: lastAdmin,
+			grant: true, slug: "role-collaborator", userID: "admin-1", code: codes.FailedPrecondition, role: "role-admin",
+		},
+		{
+			name: "removing a collaborator", members: lastAdmin,
+			slug: OrgMemberEntitlement, userID: "user-1",
+		},
+		{
+			name: "revoking the admin role of one of the admins", members: admins,
+			slug: "role-admin", userID: "admin-1", role: "role-collaborator",
+		},
+	})
+
+	runPolicyTests(t, func() *Config { return &Config{AllowLastAdminRemoval: true} }, []policyTest{
+		{
+			name: "removing the last admin when allowed", members: lastAdmin,
+			slug: OrgMemberEntitlement, userID: "admin-1",
+		},
+	})
+}
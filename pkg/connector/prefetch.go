@@ -0,0 +1,209 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Snyk API allows 2000 requests per minute per API token, leave some room for other requests made during sync.
+	prefetchRequestsPerMinute = 1500
+	prefetchMaxRetries        = 3
+	prefetchRetryBackoff      = 5 * time.Second
+
+	DefaultOrgFetchConcurrency = 10
+)
+
+type prefetchResult struct {
+	members []snyk.OrgUser
+	err     error
+}
+
+// orgPrefetcher fetches members of all synced organizations and the org roles concurrently,
+// so grants of individual organizations are served without waiting for the API. Members of every
+// organization are held in memory until the next sync, unlike members streamed per organization.
+type orgPrefetcher struct {
+	client      *snyk.Client
+	concurrency int
+	// skip reports organizations that don't need to be fetched.
+	skip func(ctx context.Context, orgID string) (bool, error)
+	// include reports organizations that are part of the sync.
+	include func(org *snyk.Org) bool
+
+	mtx     sync.Mutex
+	loaded  bool
+	roles   []snyk.Role
+	results map[string]prefetchResult
+}
+
+func newOrgPrefetcher(client *snyk.Client, concurrency int) *orgPrefetcher {
+	if concurrency <= 0 {
+		concurrency = DefaultOrgFetchConcurrency
+	}
+
+	return &orgPrefetcher{
+		client:      client,
+		concurrency: concurrency,
+	}
+}
+
+// Reset drops the prefetched data so the next lookup fetches it again.
+func (p *orgPrefetcher) Reset() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.loaded = false
+	p.roles = nil
+	p.results = nil
+}
+
+// Roles returns the prefetched org roles.
+func (p *orgPrefetcher) Roles(ctx context.Context) ([]snyk.Role, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if err := p.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+
+	return p.roles, nil
+}
+
+// Members returns the prefetched members of the organization.
+// It returns false if the organization wasn't prefetched.
+func (p *orgPrefetcher) Members(ctx context.Context, orgID string) ([]snyk.OrgUser, bool, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if err := p.ensureLoaded(ctx); err != nil {
+		return nil, false, err
+	}
+
+	res, ok := p.results[orgID]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return res.members, true, res.err
+}
+
+func (p *orgPrefetcher) ensureLoaded(ctx context.Context) error {
+	if p.loaded {
+		return nil
+	}
+
+	roles, err := p.client.ListOrgRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to list roles in org: %w", err)
+	}
+
+	orgs, err := listAllOrgs(ctx, p.client)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to list orgs: %w", err)
+	}
+
+	var orgIDs []string
+	for i, org := range orgs {
+		if p.include != nil && !p.include(&orgs[i]) {
+			continue
+		}
+
+		if p.skip != nil {
+			skip, err := p.skip(ctx, org.ID)
+			if err != nil {
+				return err
+			}
+
+			if skip {
+				continue
+			}
+		}
+
+		orgIDs = append(orgIDs, org.ID)
+	}
+
+	p.roles = roles
+	p.results = p.fetch(ctx, orgIDs)
+	p.loaded = true
+
+	return nil
+}
+
+// fetch lists members of the organizations using a bounded pool of workers.
+// Requests of all workers are paced to stay within the Snyk API rate limit.
+func (p *orgPrefetcher) fetch(ctx context.Context, orgIDs []string) map[string]prefetchResult {
+	l := ctxzap.Extract(ctx)
+	l.Debug("snyk-connector: prefetching org members", zap.Int("orgs", len(orgIDs)), zap.Int("concurrency", p.concurrency))
+
+	ticker := time.NewTicker(time.Minute / prefetchRequestsPerMinute)
+	defer ticker.Stop()
+
+	jobs := make(chan string)
+	results := make(map[string]prefetchResult, len(orgIDs))
+	var resultsMtx sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for orgID := range jobs {
+				members, err := p.fetchOrg(ctx, ticker.C, orgID)
+				if err != nil {
+					err = fmt.Errorf("snyk-connector: failed to list users in org: %w", err)
+				}
+
+				resultsMtx.Lock()
+				results[orgID] = prefetchResult{members: members, err: err}
+				resultsMtx.Unlock()
+			}
+		}()
+	}
+
+	for _, orgID := range orgIDs {
+		jobs <- orgID
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (p *orgPrefetcher) fetchOrg(ctx context.Context, pace <-chan time.Time, orgID string) ([]snyk.OrgUser, error) {
+	var err error
+	for attempt := 0; attempt <= prefetchMaxRetries; attempt++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-pace:
+		}
+
+		var members []snyk.OrgUser
+		members, err = p.client.ListUsersInOrg(ctx, orgID)
+		if err == nil {
+			return members, nil
+		}
+
+		// back off when rate limited, fail right away otherwise
+		if status.Code(err) != codes.Unavailable {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(prefetchRetryBackoff * time.Duration(attempt+1)):
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,160 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+)
+
+// principalIndex tracks which group owns each principal and which principals are excluded by the user rules.
+//
+// A user can be a member of several synced groups but is synced as a single resource, listed under
+// the first configured group it is a member of. Rules are evaluated against the membership in that group,
+// so grants in all groups stay consistent with the synced users. Tenant members that aren't members
+// of any synced group are listed under the tenant.
+type principalIndex struct {
+	groups *groupClients
+	tenant *tenantScope
+	rules  userRules
+	filter *OrgFilter
+
+	mtx      sync.Mutex
+	loaded   time.Time
+	owners   map[string]string
+	excluded map[string]struct{}
+}
+
+func newPrincipalIndex(groups *groupClients, tenant *tenantScope, rules userRules, filter *OrgFilter) *principalIndex {
+	return &principalIndex{
+		groups: groups,
+		tenant: tenant,
+		rules:  rules,
+		filter: filter,
+	}
+}
+
+// Reset drops the index so it is built again on the next lookup.
+func (p *principalIndex) Reset() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.loaded = time.Time{}
+	p.owners = nil
+	p.excluded = nil
+}
+
+// Expire drops the index once it is older than maxAge, so principals added since are indexed.
+func (p *principalIndex) Expire(maxAge time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if !p.loaded.IsZero() && time.Since(p.loaded) > maxAge {
+		p.loaded = time.Time{}
+		p.owners = nil
+		p.excluded = nil
+	}
+}
+
+// Load builds the index when lookups need it. It is called before principals are streamed,
+// so the responses listing every principal aren't read while another response is open.
+func (p *principalIndex) Load(ctx context.Context) error {
+	if len(p.groups.ids) <= 1 && p.tenant.ID() == "" && len(p.rules) == 0 {
+		return nil
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.ensureLoaded(ctx)
+}
+
+// IsOwner reports whether the user is synced under the group or the tenant. The index must be loaded.
+func (p *principalIndex) IsOwner(ownerID, userID string) bool {
+	if len(p.groups.ids) <= 1 && ownerID != p.tenant.ID() {
+		return true
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.owners[userID] == ownerID
+}
+
+// IsExcluded reports whether the principal is excluded from the sync by the user rules. The index must be loaded.
+func (p *principalIndex) IsExcluded(userID string) bool {
+	if len(p.rules) == 0 {
+		return false
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	_, ok := p.excluded[userID]
+	return ok
+}
+
+func (p *principalIndex) ensureLoaded(ctx context.Context) error {
+	if !p.loaded.IsZero() {
+		return nil
+	}
+
+	start := time.Now()
+	owners := make(map[string]string)
+	excluded := make(map[string]struct{})
+
+	if p.groups.OrgTokenMode() {
+		err := forEachOrgTokenUser(ctx, p.groups.orgClient, p.filter, func(user *snyk.GroupUser) error {
+			if ok, _ := p.rules.Evaluate(user); ok {
+				excluded[user.ID] = struct{}{}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, groupID := range p.groups.ids {
+		err := p.groups.clients[groupID].ForEachUserInGroup(ctx, func(user *snyk.GroupUser) error {
+			if _, ok := owners[user.ID]; ok {
+				return nil
+			}
+
+			owners[user.ID] = groupID
+			if ok, _ := p.rules.Evaluate(user); ok {
+				excluded[user.ID] = struct{}{}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("snyk-connector: failed to list users in group %s: %w", groupID, err)
+		}
+	}
+
+	err := forEachTenantUser(ctx, p.tenant, func(user *snyk.GroupUser) error {
+		if _, ok := owners[user.ID]; ok {
+			return nil
+		}
+
+		owners[user.ID] = p.tenant.ID()
+		if ok, _ := p.rules.Evaluate(user); ok {
+			excluded[user.ID] = struct{}{}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	p.owners = owners
+	p.excluded = excluded
+	p.loaded = start
+
+	return nil
+}
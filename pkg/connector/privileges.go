@@ -0,0 +1,277 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	CapabilityIdentity          = "identity"
+	CapabilityListMembers       = "list_members"
+	CapabilityListRoles         = "list_roles"
+	CapabilityManageMemberships = "manage_memberships"
+
+	PrivilegeGranted = "granted"
+	PrivilegeMissing = "missing"
+	PrivilegeUnknown = "unknown"
+)
+
+// privilegeCheck is the result of probing a single capability of the token in a scope (tenant, group or org).
+type privilegeCheck struct {
+	Scope      string
+	Capability string
+	Status     string
+	Detail     string
+}
+
+func (c privilegeCheck) String() string {
+	return fmt.Sprintf("%s %s: %s (%s)", c.Scope, c.Capability, c.Status, c.Detail)
+}
+
+// privilegeReport lists capabilities of the token detected by Validate.
+type privilegeReport []privilegeCheck
+
+func (r *privilegeReport) add(scope, capability, status, detail string) {
+	*r = append(*r, privilegeCheck{Scope: scope, Capability: capability, Status: status, Detail: detail})
+}
+
+// Missing returns checks of the capabilities the token doesn't have.
+func (r privilegeReport) Missing(capabilities ...string) privilegeReport {
+	var rv privilegeReport
+	for _, c := range r {
+		if c.Status != PrivilegeMissing {
+			continue
+		}
+
+		for _, capability := range capabilities {
+			if c.Capability == capability {
+				rv = append(rv, c)
+			}
+		}
+	}
+
+	return rv
+}
+
+func (r privilegeReport) String() string {
+	checks := make([]string, 0, len(r))
+	for _, c := range r {
+		checks = append(checks, c.String())
+	}
+
+	return strings.Join(checks, "; ")
+}
+
+// Annotations returns the report as a struct annotation listing every check, so callers of Validate see it.
+func (r privilegeReport) Annotations() (annotations.Annotations, error) {
+	checks := make([]any, 0, len(r))
+	for _, c := range r {
+		checks = append(checks, map[string]any{
+			"scope":      c.Scope,
+			"capability": c.Capability,
+			"status":     c.Status,
+			"detail":     c.Detail,
+		})
+	}
+
+	report, err := structpb.NewStruct(map[string]any{"privileges": checks})
+	if err != nil {
+		return nil, err
+	}
+
+	annos := annotations.Annotations{}
+	annos.Update(report)
+	return annos, nil
+}
+
+// Log writes every check of the report, warning about capabilities that aren't granted.
+func (r privilegeReport) Log(ctx context.Context) {
+	l := ctxzap.Extract(ctx)
+	for _, c := range r {
+		fields := []zap.Field{
+			zap.String("scope", c.Scope),
+			zap.String("capability", c.Capability),
+			zap.String("status", c.Status),
+			zap.String("detail", c.Detail),
+		}
+
+		if c.Status == PrivilegeGranted {
+			l.Info("snyk-connector: token capability", fields...)
+		} else {
+			l.Warn("snyk-connector: token capability", fields...)
+		}
+	}
+}
+
+// probePrivileges detects capabilities of the token without mutating anything.
+//
+// Listing members and roles is probed by calling the endpoints used during sync. Managing memberships
+// can't be probed without a write, so it is derived from the role the token's identity holds: admins of the
+// group (or the organization in org-token mode) can manage memberships. Service accounts aren't listed as
+// members, so their ability to manage memberships is reported as unknown. In org-token mode only the first
+// selected organization is probed, so validation doesn't list members of every organization. The role of the
+// token may differ in the others.
+func probePrivileges(
+	ctx context.Context,
+	groups *groupClients,
+	tenantScope *tenantScope,
+	filter *OrgFilter,
+	policy *provisioningPolicy,
+) (privilegeReport, error) {
+	var report privilegeReport
+
+	client := groups.orgClient
+	if client == nil {
+		client = groups.clients[groups.ids[0]]
+	}
+
+	self, err := client.GetCurrentUser(ctx)
+	if err != nil {
+		report.add("token", CapabilityIdentity, PrivilegeMissing, err.Error())
+		return report, nil
+	}
+
+	report.add("token", CapabilityIdentity, PrivilegeGranted, fmt.Sprintf("authenticated as %s (%s)", self.Username, self.ID))
+
+	tenant, err := tenantScope.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant != nil {
+		probeTenant(ctx, &report, tenantScope.client, tenant, self, policy)
+	}
+
+	if groups.OrgTokenMode() {
+		return report, probeOrgTokenOrgs(ctx, &report, groups.orgClient, filter, self)
+	}
+
+	for _, groupID := range groups.ids {
+		probeGroup(ctx, &report, groups.clients[groupID], self)
+	}
+
+	return report, nil
+}
+
+func probeTenant(ctx context.Context, report *privilegeReport, client *snyk.Client, tenant *snyk.Tenant, self *snyk.BaseUser, policy *provisioningPolicy) {
+	scope := fmt.Sprintf("tenant %s", tenant.ID)
+
+	if _, err := client.ListTenantRoles(ctx, tenant.ID); err != nil {
+		report.add(scope, CapabilityListRoles, PrivilegeMissing, err.Error())
+	} else {
+		report.add(scope, CapabilityListRoles, PrivilegeGranted, "tenant roles are listed")
+	}
+
+	memberships, err := client.ListTenantMemberships(ctx, tenant.ID)
+	if err != nil {
+		report.add(scope, CapabilityListMembers, PrivilegeMissing, err.Error())
+		report.add(scope, CapabilityManageMemberships, PrivilegeUnknown, "tenant memberships can't be listed")
+		return
+	}
+
+	report.add(scope, CapabilityListMembers, PrivilegeGranted, fmt.Sprintf("%d tenant members", len(memberships)))
+
+	for _, m := range memberships {
+		if m.User.ID != self.ID {
+			continue
+		}
+
+		if policy.isTenantAdmin(&m.Role) {
+			report.add(scope, CapabilityManageMemberships, PrivilegeGranted, fmt.Sprintf("token has the %s role", m.Role.Name))
+		} else {
+			report.add(scope, CapabilityManageMemberships, PrivilegeMissing, fmt.Sprintf("token has the %s role, admin is required", m.Role.Name))
+		}
+
+		return
+	}
+
+	report.add(scope, CapabilityManageMemberships, PrivilegeUnknown, "token identity is not a tenant member")
+}
+
+func probeGroup(ctx context.Context, report *privilegeReport, client *snyk.Client, self *snyk.BaseUser) {
+	scope := fmt.Sprintf("group %s", client.GroupID())
+
+	if _, err := client.ListOrgRoles(ctx); err != nil {
+		report.add(scope, CapabilityListRoles, PrivilegeMissing, err.Error())
+	} else {
+		report.add(scope, CapabilityListRoles, PrivilegeGranted, "org roles are listed")
+	}
+
+	count := 0
+	selfRole := ""
+	err := client.ForEachUserInGroup(ctx, func(user *snyk.GroupUser) error {
+		count++
+		if user.ID == self.ID {
+			selfRole = user.Role
+		}
+
+		return nil
+	})
+	if err != nil {
+		report.add(scope, CapabilityListMembers, PrivilegeMissing, err.Error())
+		report.add(scope, CapabilityManageMemberships, PrivilegeUnknown, "group members can't be listed")
+		return
+	}
+
+	report.add(scope, CapabilityListMembers, PrivilegeGranted, fmt.Sprintf("%d group members", count))
+
+	switch selfRole {
+	case "":
+		report.add(scope, CapabilityManageMemberships, PrivilegeUnknown, "token identity is not a group member, e.g. a service account")
+	case AdminRole:
+		report.add(scope, CapabilityManageMemberships, PrivilegeGranted, "token has the group admin role")
+	default:
+		report.add(scope, CapabilityManageMemberships, PrivilegeMissing, fmt.Sprintf("token has the group %s role, admin is required", selfRole))
+	}
+}
+
+func probeOrgTokenOrgs(ctx context.Context, report *privilegeReport, client *snyk.Client, filter *OrgFilter, self *snyk.BaseUser) error {
+	orgs, err := listOrgTokenOrgs(ctx, client, filter)
+	if err != nil {
+		return err
+	}
+
+	// roles are built-in without a group
+	report.add("orgs", CapabilityListRoles, PrivilegeGranted, "built-in admin and collaborator roles")
+
+	if len(orgs) == 0 {
+		report.add("orgs", CapabilityListMembers, PrivilegeUnknown, "no organization is selected by the org filter")
+		return nil
+	}
+
+	org := orgs[0]
+	scope := fmt.Sprintf("org %s", org.ID)
+
+	selfRole := ""
+	err = client.ForEachUserInOrg(ctx, org.ID, func(user *snyk.OrgUser) error {
+		if user.ID == self.ID {
+			selfRole = user.Role
+		}
+
+		return nil
+	})
+	if err != nil {
+		report.add(scope, CapabilityListMembers, PrivilegeMissing, err.Error())
+		return nil
+	}
+
+	report.add(scope, CapabilityListMembers, PrivilegeGranted, fmt.Sprintf("org members are listed, probed 1 of %d orgs", len(orgs)))
+
+	switch selfRole {
+	case "":
+		report.add(scope, CapabilityManageMemberships, PrivilegeUnknown, "token identity is not an org member, e.g. a service account")
+	case snyk.OrgAdminRole:
+		report.add(scope, CapabilityManageMemberships, PrivilegeGranted, "token has the org admin role")
+	default:
+		report.add(scope, CapabilityManageMemberships, PrivilegeMissing, fmt.Sprintf("token has the org %s role, admin is required", selfRole))
+	}
+
+	return nil
+}
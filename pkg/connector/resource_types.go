@@ -5,6 +5,13 @@ import (
 )
 
 var (
+	// The tenant resource type is for the tenant above the groups.
+	tenantResourceType = &v2.ResourceType{
+		Id:          "tenant",
+		DisplayName: "Tenant",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
+	}
+
 	// The group resource type is for all group objects from the database.
 	groupResourceType = &v2.ResourceType{
 		Id:          "group",
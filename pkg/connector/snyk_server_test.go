@@ -0,0 +1,422 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+)
+
+const testGroupID = "group-1"
+
+// fakeRole is an org role of the fake group, listed with the public ID and name like the v1 API does.
+type fakeRole struct {
+	ID   string `json:"publicId"`
+	Name string `json:"name"`
+}
+
+// slug returns the role slug the way the v1 org members endpoint lists it, the lowercased second word of the name.
+func (r fakeRole) slug() string {
+	fields := strings.Fields(strings.ToLower(r.Name))
+	if len(fields) < 2 {
+		return ""
+	}
+
+	return fields[1]
+}
+
+// fakeMember is a member of a fake organization and the public ID of its role.
+type fakeMember struct {
+	ID     string
+	Email  string
+	RoleID string
+}
+
+// fakeSnyk serves the parts of the Snyk API used by provisioning from memory. It records every request
+// that isn't a GET, so tests can check what was changed.
+type fakeSnyk struct {
+	t *testing.T
+
+	mtx    sync.Mutex
+	selfID string
+	roles  []fakeRole
+	orgs   map[string][]fakeMember
+	// users are members of the group without any organization, admins are IDs of the group admins,
+	// listed as admins of every organization when group admins are included
+	users  []fakeMember
+	admins []string
+	writes []string
+}
+
+var fakeBuiltinRoles = []fakeRole{
+	{ID: "role-admin", Name: "Org Admin"},
+	{ID: "role-collaborator", Name: "Org Collaborator"},
+}
+
+func newFakeSnyk(t *testing.T) *fakeSnyk {
+	return &fakeSnyk{
+		t:      t,
+		selfID: "svc",
+		roles:  append([]fakeRole(nil), fakeBuiltinRoles...),
+		orgs:   make(map[string][]fakeMember),
+	}
+}
+
+// connector starts the fake API and returns a connector configured against it with the group and journal.
+func (f *fakeSnyk) connector(cfg *Config) *Snyk {
+	f.t.Helper()
+
+	// the response cache would serve members listed before a change
+	f.t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/user/me", f.handleSelf)
+	mux.HandleFunc("GET /v1/group/{group}/roles", f.handleRoles)
+	mux.HandleFunc("GET /v1/org/{org}/members", f.handleMembers)
+	mux.HandleFunc("POST /v1/group/{group}/org/{org}/members", f.handleAddMember)
+	mux.HandleFunc("PUT /v1/org/{org}/members/update/{user}", f.handleUpdateRole)
+	mux.HandleFunc("DELETE /v1/org/{org}/members/{user}", f.handleRemoveMember)
+	mux.HandleFunc("GET /v1/group/{group}/orgs", f.handleOrgs)
+	mux.HandleFunc("GET /v1/group/{group}/members", f.handleGroupMembers)
+	mux.HandleFunc("GET /rest/orgs/{org}/memberships", f.handleMemberships)
+
+	srv := httptest.NewServer(f.record(mux))
+	f.t.Cleanup(srv.Close)
+
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	cfg.Token = "token"
+	cfg.GroupIDs = []string{testGroupID}
+	if cfg.JournalPath == "" {
+		cfg.JournalPath = f.t.TempDir() + "/journal.jsonl"
+	}
+
+	s, err := New(context.Background(), cfg)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	s.client.SetBaseURL(base)
+	for _, client := range s.groups.clients {
+		client.SetBaseURL(base)
+	}
+
+	return s
+}
+
+func (f *fakeSnyk) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			f.mtx.Lock()
+			f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+			f.mtx.Unlock()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Writes returns the requests changing Snyk received so far.
+func (f *fakeSnyk) Writes() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return append([]string(nil), f.writes...)
+}
+
+// Role returns the public ID of the role of the org member, or an empty string if the user isn't a member.
+func (f *fakeSnyk) Role(orgID, userID string) string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for _, m := range f.orgs[orgID] {
+		if m.ID == userID {
+			return m.RoleID
+		}
+	}
+
+	return ""
+}
+
+func (f *fakeSnyk) findRole(roleID string) (fakeRole, bool) {
+	for _, r := range f.roles {
+		if r.ID == roleID {
+			return r, true
+		}
+	}
+
+	return fakeRole{}, false
+}
+
+// email returns the email of the group member.
+func (f *fakeSnyk) email(userID string) string {
+	for _, u := range f.users {
+		if u.ID == userID {
+			return u.Email
+		}
+	}
+
+	for _, members := range f.orgs {
+		for _, m := range members {
+			if m.ID == userID {
+				return m.Email
+			}
+		}
+	}
+
+	return ""
+}
+
+func (f *fakeSnyk) setMember(orgID string, member fakeMember) {
+	members := f.orgs[orgID]
+	for i := range members {
+		if members[i].ID == member.ID {
+			members[i] = member
+			return
+		}
+	}
+
+	f.orgs[orgID] = append(members, member)
+}
+
+func (f *fakeSnyk) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		f.t.Error(err)
+	}
+}
+
+func (f *fakeSnyk) handleSelf(w http.ResponseWriter, _ *http.Request) {
+	f.writeJSON(w, snyk.BaseUser{BaseResource: snyk.BaseResource{ID: f.selfID}})
+}
+
+func (f *fakeSnyk) handleRoles(w http.ResponseWriter, _ *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.writeJSON(w, f.roles)
+}
+
+func (f *fakeSnyk) handleMembers(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	rv := []snyk.OrgUser{}
+	for _, m := range f.orgs[r.PathValue("org")] {
+		role, _ := f.findRole(m.RoleID)
+		rv = append(rv, snyk.OrgUser{
+			BaseUser: snyk.BaseUser{BaseResource: snyk.BaseResource{ID: m.ID}, Email: m.Email},
+			Role:     role.slug(),
+		})
+	}
+
+	if r.URL.Query().Get("includeGroupAdmins") == "true" {
+		for _, id := range f.admins {
+			if slices.ContainsFunc(rv, func(u snyk.OrgUser) bool { return u.ID == id }) {
+				continue
+			}
+
+			rv = append(rv, snyk.OrgUser{BaseUser: snyk.BaseUser{BaseResource: snyk.BaseResource{ID: id}}, Role: snyk.OrgAdminRole})
+		}
+	}
+
+	f.writeJSON(w, rv)
+}
+
+// handleGroupMembers lists members of every organization, the group admins and users without any organization.
+func (f *fakeSnyk) handleGroupMembers(w http.ResponseWriter, _ *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	rv := []snyk.GroupUser{}
+	seen := make(map[string]struct{})
+	add := func(id, email, role string) {
+		if _, ok := seen[id]; ok {
+			return
+		}
+
+		seen[id] = struct{}{}
+		rv = append(rv, snyk.GroupUser{BaseUser: snyk.BaseUser{BaseResource: snyk.BaseResource{ID: id}, Email: email}, Role: role})
+	}
+
+	for _, id := range f.admins {
+		add(id, "", "admin")
+	}
+
+	for _, members := range f.orgs {
+		for _, m := range members {
+			add(m.ID, m.Email, "member")
+		}
+	}
+
+	for _, u := range f.users {
+		add(u.ID, u.Email, "member")
+	}
+
+	f.writeJSON(w, rv)
+}
+
+func (f *fakeSnyk) handleOrgs(w http.ResponseWriter, _ *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	var res struct {
+		Orgs []snyk.Org `json:"orgs"`
+	}
+	for orgID := range f.orgs {
+		res.Orgs = append(res.Orgs, snyk.Org{BaseResource: snyk.BaseResource{ID: orgID}, Name: orgID, Slug: orgID})
+	}
+
+	slices.SortFunc(res.Orgs, func(a, b snyk.Org) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	w.Header().Set("Link", "</v1/group/"+testGroupID+"/orgs>; rel=last")
+	f.writeJSON(w, res)
+}
+
+// handleMemberships lists the org memberships the way the REST API does, with the role ID and name.
+func (f *fakeSnyk) handleMemberships(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	type attributes struct {
+		Name  string `json:"name,omitempty"`
+		Email string `json:"email,omitempty"`
+	}
+
+	type relationship struct {
+		Data struct {
+			ID         string     `json:"id"`
+			Type       string     `json:"type"`
+			Attributes attributes `json:"attributes"`
+		} `json:"data"`
+	}
+
+	type membership struct {
+		ID            string                  `json:"id"`
+		Type          string                  `json:"type"`
+		Relationships map[string]relationship `json:"relationships"`
+	}
+
+	res := struct {
+		Data []membership `json:"data"`
+	}{Data: []membership{}}
+
+	for _, m := range f.orgs[r.PathValue("org")] {
+		role, _ := f.findRole(m.RoleID)
+
+		var user, orgRole relationship
+		user.Data.ID, user.Data.Type, user.Data.Attributes.Email = m.ID, "user", m.Email
+		orgRole.Data.ID, orgRole.Data.Type, orgRole.Data.Attributes.Name = role.ID, "org_role", role.Name
+
+		res.Data = append(res.Data, membership{
+			ID:            "membership-" + m.ID,
+			Type:          "org_membership",
+			Relationships: map[string]relationship{"user": user, "role": orgRole},
+		})
+	}
+
+	f.writeJSON(w, res)
+}
+
+func (f *fakeSnyk) handleAddMember(w http.ResponseWriter, r *http.Request) {
+	var body snyk.AddMemberBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	for _, role := range f.roles {
+		if role.slug() == body.Role {
+			f.setMember(r.PathValue("org"), fakeMember{ID: body.UserId, Email: f.email(body.UserId), RoleID: role.ID})
+			return
+		}
+	}
+
+	http.Error(w, "unknown role", http.StatusBadRequest)
+}
+
+func (f *fakeSnyk) handleUpdateRole(w http.ResponseWriter, r *http.Request) {
+	var body snyk.UpdateRoleBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if _, ok := f.findRole(body.RoleID); !ok {
+		http.Error(w, "unknown role", http.StatusBadRequest)
+		return
+	}
+
+	members := f.orgs[r.PathValue("org")]
+	for i := range members {
+		if members[i].ID == r.PathValue("user") {
+			members[i].RoleID = body.RoleID
+			return
+		}
+	}
+
+	http.Error(w, "not a member", http.StatusNotFound)
+}
+
+func (f *fakeSnyk) handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	orgID := r.PathValue("org")
+	members := f.orgs[orgID]
+	for i := range members {
+		if members[i].ID == r.PathValue("user") {
+			f.orgs[orgID] = append(members[:i], members[i+1:]...)
+			return
+		}
+	}
+
+	http.Error(w, "not a member", http.StatusNotFound)
+}
+
+func testOrgResource(orgID string) *v2.Resource {
+	return &v2.Resource{
+		Id:               &v2.ResourceId{ResourceType: orgResourceType.Id, Resource: orgID},
+		ParentResourceId: &v2.ResourceId{ResourceType: groupResourceType.Id, Resource: testGroupID},
+	}
+}
+
+func testUserResource(userID string) *v2.Resource {
+	return &v2.Resource{Id: &v2.ResourceId{ResourceType: userResourceType.Id, Resource: userID}}
+}
+
+// testOrgGrant returns the grant of the org entitlement, either the membership or the public ID of a role.
+func testOrgGrant(orgID, slug, userID string) *v2.Grant {
+	return &v2.Grant{
+		Entitlement: &v2.Entitlement{Resource: testOrgResource(orgID), Slug: slug},
+		Principal:   testUserResource(userID),
+	}
+}
+
+// testOrgBuilder returns the org builder provisioning through the connector, the way Undo builds it.
+func testOrgBuilder(s *Snyk) *orgBuilder {
+	return newOrgBuilder(s.groups, s.OrgFilter, nil, s.policy, s.verifier, s.journal, nil, false, false, 0)
+}
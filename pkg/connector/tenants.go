@@ -0,0 +1,509 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	TenantMemberEntitlement = "member"
+
+	// DefaultTenantMemberRole is the minimal tenant role, assigned to users added as plain members.
+	DefaultTenantMemberRole = "Tenant Member"
+	// DefaultTenantAdminRole is the tenant role allowed to manage the tenant.
+	DefaultTenantAdminRole = "Tenant Admin"
+)
+
+// tenantScope resolves whether the token has access to the configured tenant.
+// The tenant is looked up once, so all builders agree on the shape of the resource hierarchy.
+type tenantScope struct {
+	client   *snyk.Client
+	id       string
+	verifier *writeVerifier
+	journal  *provisioningJournal
+
+	mtx     sync.Mutex
+	checked bool
+	tenant  *snyk.Tenant
+}
+
+func newTenantScope(client *snyk.Client, tenantID string, verifier *writeVerifier, journal *provisioningJournal) *tenantScope {
+	return &tenantScope{
+		client:   client,
+		id:       tenantID,
+		verifier: verifier,
+		journal:  journal,
+	}
+}
+
+// Get returns the tenant, or nil if no tenant is configured or the token doesn't have tenant scope.
+func (t *tenantScope) Get(ctx context.Context) (*snyk.Tenant, error) {
+	if t == nil || t.id == "" {
+		return nil, nil
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.checked {
+		return t.tenant, nil
+	}
+
+	tenant, err := t.client.GetTenant(ctx, t.id)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.PermissionDenied, codes.NotFound:
+			// sync groups at the top level when the token is scoped to the groups only
+			l := ctxzap.Extract(ctx)
+			l.Warn("snyk-connector: token doesn't have access to the tenant, syncing groups without it", zap.String("tenant_id", t.id), zap.Error(err))
+		default:
+			return nil, fmt.Errorf("snyk-connector: failed to get tenant %s: %w", t.id, err)
+		}
+	}
+
+	t.tenant = tenant
+	t.checked = true
+
+	return t.tenant, nil
+}
+
+// ID returns ID of the configured tenant.
+func (t *tenantScope) ID() string {
+	if t == nil {
+		return ""
+	}
+
+	return t.id
+}
+
+// Nested reports whether groups are synced as children of the tenant.
+func (t *tenantScope) Nested(ctx context.Context) (bool, error) {
+	tenant, err := t.Get(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return tenant != nil, nil
+}
+
+// findMembership returns membership of the user in the tenant, or nil if the user isn't a member,
+// along with memberships of all tenant members. Memberships are read directly from the API, so changes made
+// since the sync are accounted for.
+func (t *tenantScope) findMembership(ctx context.Context, tenantID, userID string) (*snyk.TenantMembership, []snyk.TenantMembership, error) {
+	memberships, err := t.client.LookupTenantMemberships(ctx, tenantID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("snyk-connector: failed to list tenant memberships: %w", err)
+	}
+
+	i := slices.IndexFunc(memberships, func(m snyk.TenantMembership) bool {
+		return m.User.ID == userID
+	})
+	if i == -1 {
+		return nil, memberships, nil
+	}
+
+	return &memberships[i], memberships, nil
+}
+
+// addMember adds the user to the tenant with the role.
+func (t *tenantScope) addMember(ctx context.Context, tenantID, userID string, role *snyk.TenantRole) error {
+	err := t.client.AddTenantMember(ctx, tenantID, userID, role.ID)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to add user to tenant: %w", err)
+	}
+
+	err = t.journal.record(ctx, JournalEntry{
+		Scope:    JournalScopeTenant,
+		Action:   JournalAdd,
+		TenantID: tenantID,
+		UserID:   userID,
+		NewRole:  role.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.verifier.tenantMember(ctx, t, tenantID, userID, role)
+}
+
+// setRole replaces the role of the tenant member with the role.
+func (t *tenantScope) setRole(ctx context.Context, tenantID string, membership *snyk.TenantMembership, role *snyk.TenantRole) error {
+	err := t.client.UpdateTenantMembership(ctx, tenantID, membership.ID, role.ID)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to update user role in tenant: %w", err)
+	}
+
+	err = t.journal.record(ctx, JournalEntry{
+		Scope:        JournalScopeTenant,
+		Action:       JournalUpdate,
+		TenantID:     tenantID,
+		UserID:       membership.User.ID,
+		MembershipID: membership.ID,
+		PreviousRole: membership.Role.ID,
+		NewRole:      role.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.verifier.tenantMember(ctx, t, tenantID, membership.User.ID, role)
+}
+
+// removeMember removes the member from the tenant.
+func (t *tenantScope) removeMember(ctx context.Context, tenantID string, membership *snyk.TenantMembership) error {
+	err := t.client.RemoveTenantMembership(ctx, tenantID, membership.ID)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to remove user from tenant: %w", err)
+	}
+
+	err = t.journal.record(ctx, JournalEntry{
+		Scope:        JournalScopeTenant,
+		Action:       JournalRemove,
+		TenantID:     tenantID,
+		UserID:       membership.User.ID,
+		MembershipID: membership.ID,
+		PreviousRole: membership.Role.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.verifier.tenantMember(ctx, t, tenantID, membership.User.ID, nil)
+}
+
+type tenantBuilder struct {
+	tenant     *tenantScope
+	principals *principalIndex
+	policy     *provisioningPolicy
+}
+
+func (t *tenantBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return tenantResourceType
+}
+
+func tenantResource(ctx context.Context, tenant *snyk.Tenant) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"displayName": tenant.Name,
+		"slug":        tenant.Slug,
+	}
+
+	resource, err := rs.NewGroupResource(
+		tenant.Name,
+		tenantResourceType,
+		tenant.ID,
+		[]rs.GroupTraitOption{
+			rs.WithGroupProfile(profile),
+		},
+		rs.WithAnnotation(
+			&v2.ChildResourceType{ResourceTypeId: groupResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: userResourceType.Id},
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+// List returns the tenant when the token has tenant scope.
+func (t *tenantBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if parentResourceID != nil {
+		return nil, "", nil, nil
+	}
+
+	tenant, err := t.tenant.Get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if tenant == nil {
+		return nil, "", nil, nil
+	}
+
+	// the tenant is the root of the sync - drop principals indexed by the previous one
+	t.principals.Reset()
+
+	resource, err := tenantResource(ctx, tenant)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("snyk-connector: failed to create tenant resource: %w", err)
+	}
+
+	return []*v2.Resource{resource}, "", nil, nil
+}
+
+// Entitlements returns the membership entitlement and an entitlement for every tenant role.
+func (t *tenantBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+
+	assignmentOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(userResourceType),
+		ent.WithDisplayName(fmt.Sprintf("%s %s", resource.DisplayName, TenantMemberEntitlement)),
+		ent.WithDescription(fmt.Sprintf("Member of the %s tenant", resource.DisplayName)),
+	}
+
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, TenantMemberEntitlement, assignmentOptions...))
+
+	roles, err := t.tenant.client.ListTenantRoles(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list roles in tenant: %w", err)
+	}
+
+	for _, role := range roles {
+		permissionOptions := []ent.EntitlementOption{
+			ent.WithGrantableTo(userResourceType),
+			ent.WithDisplayName(role.Name),
+			ent.WithDescription(role.Description),
+		}
+
+		rv = append(rv, ent.NewPermissionEntitlement(resource, role.ID, permissionOptions...))
+	}
+
+	return rv, "", nil, nil
+}
+
+// Grants returns membership and role grants of all tenant members.
+func (t *tenantBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	memberships, err := t.tenant.client.ListTenantMemberships(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list tenant memberships: %w", err)
+	}
+
+	if err := t.principals.Load(ctx); err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	for _, membership := range memberships {
+		if t.principals.IsExcluded(membership.User.ID) {
+			continue
+		}
+
+		userId, err := rs.NewResourceID(userResourceType, membership.User.ID)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("snyk-connector: failed to create user resource id: %w", err)
+		}
+
+		rv = append(rv, grant.NewGrant(resource, TenantMemberEntitlement, userId))
+
+		if membership.Role.ID != "" {
+			rv = append(rv, grant.NewGrant(resource, membership.Role.ID, userId))
+		}
+	}
+
+	return rv, "", nil, nil
+}
+
+// memberRole returns the minimal tenant role.
+func (t *tenantBuilder) memberRole(ctx context.Context, tenantID string) (*snyk.TenantRole, error) {
+	roles, err := t.tenant.client.ListTenantRoles(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to list roles in tenant: %w", err)
+	}
+
+	return t.policy.tenantMember(roles)
+}
+
+func (t *tenantBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Debug(
+			"snyk-connector: only users can be granted tenant entitlements",
+			zap.String("principal_id", principal.Id.String()),
+			zap.String("principal_type", principal.Id.ResourceType),
+		)
+
+		return nil, status.Error(codes.InvalidArgument, "snyk-connector: only users can be granted tenant entitlements")
+	}
+
+	tenantID := entitlement.Resource.Id.Resource
+	userID := principal.Id.Resource
+
+	membership, memberships, err := t.tenant.findMembership(ctx, tenantID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if entitlement.Slug == TenantMemberEntitlement && membership != nil {
+		return alreadyGranted(ctx, principal, entitlement)
+	}
+
+	roles, err := t.tenant.client.ListTenantRoles(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("snyk-connector: failed to list roles in tenant: %w", err)
+	}
+
+	var role *snyk.TenantRole
+	if entitlement.Slug == TenantMemberEntitlement {
+		role, err = t.policy.tenantMember(roles)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rI := slices.IndexFunc(roles, func(r snyk.TenantRole) bool {
+			return r.ID == entitlement.Slug
+		})
+		if rI == -1 {
+			return nil, status.Errorf(codes.NotFound, "snyk-connector: role %s not found", entitlement.Slug)
+		}
+
+		role = &roles[rI]
+	}
+
+	if membership == nil {
+		err = t.tenant.addMember(ctx, tenantID, userID, role)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+
+	if membership.Role.ID == role.ID {
+		return alreadyGranted(ctx, principal, entitlement)
+	}
+
+	// assigning any other role than admin could demote the member, promotions to admin are always allowed
+	if !t.policy.isTenantAdmin(role) {
+		if err := t.policy.checkProtected(ctx, userID, membershipEmail(membership)); err != nil {
+			return nil, err
+		}
+
+		if err := t.policy.checkTenantAdmins(tenantID, userID, memberships); err != nil {
+			return nil, err
+		}
+	}
+
+	err = t.tenant.setRole(ctx, tenantID, membership, role)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (t *tenantBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	principal := grant.Principal
+	entitlement := grant.Entitlement
+
+	if principal.Id.ResourceType != userResourceType.Id {
+		l.Debug(
+			"snyk-connector: only users can have tenant entitlements revoked",
+			zap.String("principal_id", principal.Id.String()),
+			zap.String("principal_type", principal.Id.ResourceType),
+		)
+
+		return nil, status.Error(codes.InvalidArgument, "snyk-connector: only users can have tenant entitlements revoked")
+	}
+
+	tenantID := entitlement.Resource.Id.Resource
+
+	membership, memberships, err := t.tenant.findMembership(ctx, tenantID, principal.Id.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if membership == nil {
+		return alreadyRevoked(ctx, principal, entitlement)
+	}
+
+	if entitlement.Slug != TenantMemberEntitlement && membership.Role.ID != entitlement.Slug {
+		return alreadyRevoked(ctx, principal, entitlement)
+	}
+
+	if err := t.policy.checkProtected(ctx, principal.Id.Resource, membershipEmail(membership)); err != nil {
+		return nil, err
+	}
+
+	// every remaining revoke either demotes or removes the member
+	if err := t.policy.checkTenantAdmins(tenantID, principal.Id.Resource, memberships); err != nil {
+		return nil, err
+	}
+
+	if entitlement.Slug != TenantMemberEntitlement {
+		role, err := t.memberRole(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+
+		// if we're revoking admin or other role - rollback to minimal role member
+		if role.ID != entitlement.Slug {
+			l.Info(
+				"snyk-connector: demoting user to the minimal tenant role",
+				zap.String("tenant_id", tenantID),
+				zap.String("user_id", principal.Id.Resource),
+				zap.String("revoked_role_id", entitlement.Slug),
+				zap.String("role_id", role.ID),
+				zap.String("role", role.Name),
+			)
+
+			err = t.tenant.setRole(ctx, tenantID, membership, role)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, nil
+		}
+	}
+
+	err = t.tenant.removeMember(ctx, tenantID, membership)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// membershipEmail returns a lookup of the email of the tenant member.
+func membershipEmail(membership *snyk.TenantMembership) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		return membership.User.Email, nil
+	}
+}
+
+// forEachTenantUser calls fn once for every member of the tenant.
+// Tenant members don't carry a group role, so only the user details of the returned group user are set.
+func forEachTenantUser(ctx context.Context, tenant *tenantScope, fn func(user *snyk.GroupUser) error) error {
+	t, err := tenant.Get(ctx)
+	if err != nil || t == nil {
+		return err
+	}
+
+	memberships, err := tenant.client.ListTenantMemberships(ctx, t.ID)
+	if err != nil {
+		return fmt.Errorf("snyk-connector: failed to list tenant memberships: %w", err)
+	}
+
+	for _, membership := range memberships {
+		if err := fn(&snyk.GroupUser{BaseUser: membership.User}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTenantBuilder(tenant *tenantScope, principals *principalIndex, policy *provisioningPolicy) *tenantBuilder {
+	return &tenantBuilder{
+		tenant:     tenant,
+		principals: principals,
+		policy:     policy,
+	}
+}
@@ -0,0 +1,272 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	UndoDone    = "undone"
+	UndoSkipped = "skipped"
+	UndoFailed  = "failed"
+)
+
+// UndoFilter selects journal entries to undo, either by their IDs or by the time window they were recorded in.
+// Rollbacks made by undo are only selected by their IDs, so undoing the same window again doesn't reapply
+// the changes it rolled back.
+type UndoFilter struct {
+	OperationIDs []string
+	Since        time.Time
+	Until        time.Time
+}
+
+func (f *UndoFilter) match(entry *JournalEntry) bool {
+	if len(f.OperationIDs) > 0 {
+		return slices.Contains(f.OperationIDs, entry.ID)
+	}
+
+	if entry.Undoes != "" {
+		return false
+	}
+
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// UndoResult is the outcome of undoing a single journal entry.
+type UndoResult struct {
+	Entry  JournalEntry
+	Status string
+	Detail string
+}
+
+// Undo rolls back the journaled changes matching the filter, the most recent first. Changes are only rolled back
+// while the membership is still in the state the change left it in, so later changes made by anyone aren't
+// overwritten. Rollbacks are journaled too and go through the same protections as revokes.
+func (s *Snyk) Undo(ctx context.Context, filter UndoFilter) ([]UndoResult, error) {
+	if s.ReadOnly {
+		return nil, fmt.Errorf("snyk-connector: changes can't be undone in %s mode", ReadOnly)
+	}
+
+	if s.journal == nil {
+		return nil, fmt.Errorf("snyk-connector: %s must be configured to undo changes", JournalPath)
+	}
+
+	if len(filter.OperationIDs) == 0 && filter.Since.IsZero() {
+		return nil, fmt.Errorf("snyk-connector: operation IDs or the start of the time window to undo are required")
+	}
+
+	entries, err := s.journal.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	undone := make(map[string]struct{})
+	for _, entry := range entries {
+		if entry.Undoes != "" {
+			undone[entry.Undoes] = struct{}{}
+		}
+	}
+
+	orgs := newOrgBuilder(s.groups, s.OrgFilter, nil, s.policy, s.verifier, s.journal, nil, false, false, 0)
+
+	l := ctxzap.Extract(ctx)
+
+	var rv []UndoResult
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if !filter.match(&entry) {
+			continue
+		}
+
+		result := UndoResult{Entry: entry, Status: UndoDone}
+		if _, ok := undone[entry.ID]; ok {
+			result.Status, result.Detail = UndoSkipped, "already undone"
+		} else if entry.PreviousRole == "" && entry.NewRole == "" {
+			result.Status, result.Detail = UndoSkipped, "no roles recorded"
+		} else {
+			var err error
+			undoCtx := withUndo(ctx, entry.ID)
+			switch entry.Scope {
+			case JournalScopeOrg:
+				result.Detail, err = s.undoOrgChange(undoCtx, orgs, &entry)
+			case JournalScopeTenant:
+				result.Detail, err = s.undoTenantChange(undoCtx, &entry)
+			default:
+				result.Detail = fmt.Sprintf("unknown scope %s", entry.Scope)
+			}
+
+			switch {
+			case err != nil:
+				result.Status, result.Detail = UndoFailed, err.Error()
+			case result.Detail != "":
+				result.Status = UndoSkipped
+			}
+		}
+
+		l.Info(
+			"snyk-connector: undo",
+			zap.String("operation_id", entry.ID),
+			zap.String("status", result.Status),
+			zap.String("detail", result.Detail),
+		)
+
+		rv = append(rv, result)
+	}
+
+	return rv, nil
+}
+
+// undoOrgChange applies the inverse of the org membership change.
+// It returns the reason the change was skipped, or an empty string when it was undone.
+func (s *Snyk) undoOrgChange(ctx context.Context, orgs *orgBuilder, entry *JournalEntry) (string, error) {
+	group, ok := orgs.orgGroups[entry.GroupID]
+	if !ok {
+		return fmt.Sprintf("group %s is not configured", entry.GroupID), nil
+	}
+
+	member, err := group.findMember(ctx, entry.OrgID, entry.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	// the change is undone only if nothing changed the membership since
+	if entry.NewRole == "" && member != nil {
+		return fmt.Sprintf("user was added back with the %s role", member.Role), nil
+	}
+
+	if entry.NewRole != "" && member == nil {
+		return "user is no longer a member", nil
+	}
+
+	if entry.NewRole != "" && member.Role != entry.NewRole {
+		return fmt.Sprintf("role changed to %s since", member.Role), nil
+	}
+
+	var previous *snyk.Role
+	if entry.PreviousRole != "" {
+		roles, err := group.lookupRoles(ctx)
+		if err != nil {
+			return "", fmt.Errorf("snyk-connector: failed to list roles in org: %w", err)
+		}
+
+		previous, ok = findOrgRole(roles, entry.PreviousRole)
+		if !ok {
+			return fmt.Sprintf("previous role %s no longer exists", entry.PreviousRole), nil
+		}
+	}
+
+	if previous == nil {
+		err = s.checkOrgDemote(ctx, group, member, entry.OrgID, nil)
+		if err != nil {
+			return "", err
+		}
+
+		return "", group.removeMember(ctx, entry.UserID, entry.OrgID, member.Role)
+	}
+
+	if member == nil {
+		return "", group.addMember(ctx, entry.UserID, entry.OrgID, previous)
+	}
+
+	err = s.checkOrgDemote(ctx, group, member, entry.OrgID, previous)
+	if err != nil {
+		return "", err
+	}
+
+	return "", group.setRole(ctx, entry.UserID, entry.OrgID, member.Role, previous)
+}
+
+// checkOrgDemote applies the revoke protections to changing the role of the member, or removing it when role is nil.
+// Restoring the admin role doesn't demote the member, so it is always allowed.
+func (s *Snyk) checkOrgDemote(ctx context.Context, group *orgGroup, member *snyk.OrgUser, orgID string, role *snyk.Role) error {
+	if role != nil && role.Slug == snyk.OrgAdminRole {
+		return nil
+	}
+
+	err := s.policy.checkProtected(ctx, member.ID, func(ctx context.Context) (string, error) { return member.Email, nil })
+	if err != nil {
+		return err
+	}
+
+	return s.policy.checkOrgAdmins(ctx, group.client, orgID, member.ID)
+}
+
+// undoTenantChange applies the inverse of the tenant membership change.
+// It returns the reason the change was skipped, or an empty string when it was undone.
+func (s *Snyk) undoTenantChange(ctx context.Context, entry *JournalEntry) (string, error) {
+	if s.tenant == nil || s.tenant.ID() != entry.TenantID {
+		return fmt.Sprintf("tenant %s is not configured", entry.TenantID), nil
+	}
+
+	membership, memberships, err := s.tenant.findMembership(ctx, entry.TenantID, entry.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	// the change is undone only if nothing changed the membership since
+	if entry.NewRole == "" && membership != nil {
+		return fmt.Sprintf("user was added back with the %s role", membership.Role.Name), nil
+	}
+
+	if entry.NewRole != "" && membership == nil {
+		return "user is no longer a member", nil
+	}
+
+	if entry.NewRole != "" && membership.Role.ID != entry.NewRole {
+		return fmt.Sprintf("role changed to %s since", membership.Role.Name), nil
+	}
+
+	var previous *snyk.TenantRole
+	if entry.PreviousRole != "" {
+		roles, err := s.tenant.client.ListTenantRoles(ctx, entry.TenantID)
+		if err != nil {
+			return "", fmt.Errorf("snyk-connector: failed to list roles in tenant: %w", err)
+		}
+
+		i := slices.IndexFunc(roles, func(r snyk.TenantRole) bool {
+			return r.ID == entry.PreviousRole
+		})
+		if i == -1 {
+			return fmt.Sprintf("previous role %s no longer exists", entry.PreviousRole), nil
+		}
+
+		previous = &roles[i]
+	}
+
+	if membership == nil {
+		return "", s.tenant.addMember(ctx, entry.TenantID, entry.UserID, previous)
+	}
+
+	// restoring an admin role doesn't demote the member, so it is always allowed
+	if previous == nil || !s.policy.isTenantAdmin(previous) {
+		err = s.policy.checkProtected(ctx, entry.UserID, membershipEmail(membership))
+		if err != nil {
+			return "", err
+		}
+
+		err = s.policy.checkTenantAdmins(entry.TenantID, entry.UserID, memberships)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if previous == nil {
+		return "", s.tenant.removeMember(ctx, entry.TenantID, membership)
+	}
+
+	return "", s.tenant.setRole(ctx, entry.TenantID, membership, previous)
+}
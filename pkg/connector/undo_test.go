@@ -0,0 +1,111 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUndoWindowTwice(t *testing.T) {
+	ctx := context.Background()
+
+	f := newFakeSnyk(t)
+	f.orgs["org-1"] = []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "user-1", RoleID: "role-collaborator"},
+	}
+
+	s := f.connector(nil)
+	start := time.Now().Add(-time.Minute)
+
+	orgs := testOrgBuilder(s)
+	g := testOrgGrant("org-1", "role-admin", "user-1")
+	if _, _, err := orgs.Grant(ctx, g.Principal, g.Entitlement); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+
+	if role := f.Role("org-1", "user-1"); role != "role-admin" {
+		t.Fatalf("user-1 has role %q after the grant, want role-admin", role)
+	}
+
+	filter := UndoFilter{Since: start}
+
+	results, err := s.Undo(ctx, filter)
+	if err != nil {
+		t.Fatalf("first Undo failed: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Status != UndoDone {
+		t.Fatalf("first Undo returned %+v, want the grant undone", results)
+	}
+
+	if role := f.Role("org-1", "user-1"); role != "role-collaborator" {
+		t.Fatalf("user-1 has role %q after the first undo, want role-collaborator", role)
+	}
+
+	writes := f.Writes()
+
+	// the rollback journaled by the first run is in the window too, but must not be undone
+	results, err = s.Undo(ctx, filter)
+	if err != nil {
+		t.Fatalf("second Undo failed: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Status != UndoSkipped || results[0].Entry.Undoes != "" {
+		t.Errorf("second Undo returned %+v, want only the grant skipped as already undone", results)
+	}
+
+	if role := f.Role("org-1", "user-1"); role != "role-collaborator" {
+		t.Errorf("user-1 has role %q after the second undo, want role-collaborator", role)
+	}
+
+	if got := f.Writes(); !slices.Equal(got, writes) {
+		t.Errorf("second Undo sent %v, want no changes", got[len(writes):])
+	}
+}
+
+func TestUndoRollbackByOperationID(t *testing.T) {
+	ctx := context.Background()
+
+	f := newFakeSnyk(t)
+	f.orgs["org-1"] = []fakeMember{
+		{ID: "admin-1", RoleID: "role-admin"},
+		{ID: "user-1", RoleID: "role-collaborator"},
+	}
+
+	s := f.connector(nil)
+
+	orgs := testOrgBuilder(s)
+	g := testOrgGrant("org-1", "role-admin", "user-1")
+	if _, _, err := orgs.Grant(ctx, g.Principal, g.Entitlement); err != nil {
+		t.Fatalf("Grant failed: %v", err)
+	}
+
+	if _, err := s.Undo(ctx, UndoFilter{Since: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+
+	entries, err := s.journal.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[1].Undoes != entries[0].ID {
+		t.Fatalf("journal has %+v, want the grant and its rollback", entries)
+	}
+
+	// selecting the rollback explicitly reapplies the grant
+	results, err := s.Undo(ctx, UndoFilter{OperationIDs: []string{entries[1].ID}})
+	if err != nil {
+		t.Fatalf("Undo of the rollback failed: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Status != UndoDone {
+		t.Fatalf("Undo of the rollback returned %+v, want it undone", results)
+	}
+
+	if role := f.Role("org-1", "user-1"); role != "role-admin" {
+		t.Errorf("user-1 has role %q after undoing the rollback, want role-admin", role)
+	}
+}
@@ -0,0 +1,140 @@
+package connector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+)
+
+const (
+	UserRuleExclude = "exclude"
+	UserRuleLabel   = "label"
+
+	UserMatchEmailDomain = "email_domain"
+	UserMatchName        = "name"
+	UserMatchGroupRole   = "group_role"
+	UserMatchAccountType = "account_type"
+
+	AccountTypeUser           = "user"
+	AccountTypeServiceAccount = "service_account"
+)
+
+// UserRule excludes matching principals from the sync or labels them in their profile.
+//
+// Rules are written as "<action>:<matcher>=<value>", where action is either "exclude"
+// or "label=<label>" and matcher is one of "email_domain", "name" (regular expression),
+// "group_role" or "account_type" ("user" or "service_account").
+// For example "exclude:email_domain=contractors.example.com" or "label=bot:account_type=service_account".
+type UserRule struct {
+	Action  string
+	Label   string
+	Matcher string
+	Value   string
+
+	name *regexp.Regexp
+}
+
+// ParseUserRule parses a single rule.
+func ParseUserRule(rule string) (*UserRule, error) {
+	action, matcher, ok := strings.Cut(rule, ":")
+	if !ok {
+		return nil, fmt.Errorf("snyk-connector: invalid user rule '%s', expected <action>:<matcher>=<value>", rule)
+	}
+
+	r := &UserRule{}
+
+	switch action, label, _ := strings.Cut(action, "="); action {
+	case UserRuleExclude:
+		r.Action = UserRuleExclude
+	case UserRuleLabel:
+		if label == "" {
+			return nil, fmt.Errorf("snyk-connector: invalid user rule '%s', label must not be empty", rule)
+		}
+
+		r.Action = UserRuleLabel
+		r.Label = label
+	default:
+		return nil, fmt.Errorf("snyk-connector: invalid user rule '%s', unknown action '%s'", rule, action)
+	}
+
+	key, value, ok := strings.Cut(matcher, "=")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("snyk-connector: invalid user rule '%s', expected <matcher>=<value>", rule)
+	}
+
+	r.Matcher = key
+	r.Value = value
+
+	switch key {
+	case UserMatchEmailDomain:
+		r.Value = strings.ToLower(strings.TrimPrefix(value, "@"))
+	case UserMatchName:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("snyk-connector: invalid user rule '%s': %w", rule, err)
+		}
+
+		r.name = re
+	case UserMatchGroupRole:
+	case UserMatchAccountType:
+		if value != AccountTypeUser && value != AccountTypeServiceAccount {
+			return nil, fmt.Errorf("snyk-connector: invalid user rule '%s', unknown account type '%s'", rule, value)
+		}
+	default:
+		return nil, fmt.Errorf("snyk-connector: invalid user rule '%s', unknown matcher '%s'", rule, key)
+	}
+
+	return r, nil
+}
+
+// Match reports whether the rule applies to the user.
+func (r *UserRule) Match(user *snyk.GroupUser) bool {
+	switch r.Matcher {
+	case UserMatchEmailDomain:
+		_, domain, ok := strings.Cut(user.Email, "@")
+		return ok && strings.EqualFold(domain, r.Value)
+	case UserMatchName:
+		return r.name.MatchString(user.Name)
+	case UserMatchGroupRole:
+		return strings.EqualFold(user.Role, r.Value)
+	case UserMatchAccountType:
+		return accountType(user) == r.Value
+	}
+
+	return false
+}
+
+// accountType returns the kind of the principal.
+// Snyk doesn't report account types of group members, but service accounts are the only members without an email.
+func accountType(user *snyk.GroupUser) string {
+	if user.Email == "" {
+		return AccountTypeServiceAccount
+	}
+
+	return AccountTypeUser
+}
+
+// userRules evaluates the configured rules against group members.
+type userRules []*UserRule
+
+// Evaluate returns whether the user is excluded and labels of all matching rules.
+func (r userRules) Evaluate(user *snyk.GroupUser) (bool, []string) {
+	var labels []string
+	excluded := false
+	for _, rule := range r {
+		if !rule.Match(user) {
+			continue
+		}
+
+		switch rule.Action {
+		case UserRuleExclude:
+			excluded = true
+		case UserRuleLabel:
+			labels = append(labels, rule.Label)
+		}
+	}
+
+	return excluded, labels
+}
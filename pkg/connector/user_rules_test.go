@@ -0,0 +1,72 @@
+package connector
+
+import "testing"
+
+func TestParseUserRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		want    UserRule
+		wantErr bool
+	}{
+		{
+			name: "exclude by email domain",
+			rule: "exclude:email_domain=contractors.example.com",
+			want: UserRule{Action: UserRuleExclude, Matcher: UserMatchEmailDomain, Value: "contractors.example.com"},
+		},
+		{
+			name: "email domain is lowercased without the at sign",
+			rule: "exclude:email_domain=@Example.COM",
+			want: UserRule{Action: UserRuleExclude, Matcher: UserMatchEmailDomain, Value: "example.com"},
+		},
+		{
+			name: "label by account type",
+			rule: "label=bot:account_type=service_account",
+			want: UserRule{Action: UserRuleLabel, Label: "bot", Matcher: UserMatchAccountType, Value: AccountTypeServiceAccount},
+		},
+		{
+			name: "name pattern keeps colons and equal signs",
+			rule: "exclude:name=^svc:a=b$",
+			want: UserRule{Action: UserRuleExclude, Matcher: UserMatchName, Value: "^svc:a=b$"},
+		},
+		{
+			name: "group role",
+			rule: "label=viewers:group_role=viewer",
+			want: UserRule{Action: UserRuleLabel, Label: "viewers", Matcher: UserMatchGroupRole, Value: "viewer"},
+		},
+		{name: "missing matcher", rule: "exclude", wantErr: true},
+		{name: "unknown action", rule: "include:email_domain=example.com", wantErr: true},
+		{name: "empty label", rule: "label=:account_type=user", wantErr: true},
+		{name: "label without value", rule: "label:account_type=user", wantErr: true},
+		{name: "missing value", rule: "exclude:email_domain", wantErr: true},
+		{name: "empty value", rule: "exclude:email_domain=", wantErr: true},
+		{name: "unknown matcher", rule: "exclude:username=jane", wantErr: true},
+		{name: "invalid name pattern", rule: "exclude:name=(", wantErr: true},
+		{name: "unknown account type", rule: "exclude:account_type=robot", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUserRule(tt.rule)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseUserRule(%q) = %+v, want an error", tt.rule, got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseUserRule(%q) failed: %v", tt.rule, err)
+			}
+
+			if got.Action != tt.want.Action || got.Label != tt.want.Label || got.Matcher != tt.want.Matcher || got.Value != tt.want.Value {
+				t.Errorf("ParseUserRule(%q) = %+v, want %+v", tt.rule, *got, tt.want)
+			}
+
+			if tt.want.Matcher == UserMatchName && got.name == nil {
+				t.Errorf("ParseUserRule(%q) didn't compile the name pattern", tt.rule)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package connector
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
@@ -12,27 +14,44 @@ import (
 )
 
 type userBuilder struct {
-	client *snyk.Client
+	groups     *groupClients
+	tenant     *tenantScope
+	filter     *OrgFilter
+	rules      userRules
+	principals *principalIndex
+	activity   *activityIndex
 }
 
 func (u *userBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 	return userResourceType
 }
 
-func userResource(ctx context.Context, user *snyk.GroupUser, parentID *v2.ResourceId) (*v2.Resource, error) {
+func userResource(ctx context.Context, user *snyk.GroupUser, labels []string, activity *userActivity, parentID *v2.ResourceId) (*v2.Resource, error) {
 	profile := map[string]interface{}{
-		"displayName": user.Name,
-		"email":       user.Email,
-		"role":        user.Role,
+		"displayName":  user.Name,
+		"email":        user.Email,
+		"role":         user.Role,
+		"account_type": accountType(user),
+	}
+
+	if len(labels) > 0 {
+		profile["labels"] = strings.Join(labels, ",")
+	}
+
+	var traitOptions []rs.UserTraitOption
+	if activity != nil {
+		profile["dormant"] = activity.Dormant
+		if !activity.Last.IsZero() {
+			profile["last_activity"] = activity.Last.UTC().Format(time.RFC3339)
+			traitOptions = append(traitOptions, rs.WithLastLogin(activity.Last))
+		}
 	}
 
 	resource, err := rs.NewUserResource(
 		user.Name,
 		userResourceType,
 		user.ID,
-		[]rs.UserTraitOption{
-			rs.WithUserProfile(profile),
-		},
+		append([]rs.UserTraitOption{rs.WithUserProfile(profile)}, traitOptions...),
 		rs.WithParentResourceID(parentID),
 	)
 	if err != nil {
@@ -43,25 +62,111 @@ func userResource(ctx context.Context, user *snyk.GroupUser, parentID *v2.Resour
 }
 
 // List returns all the users from the database as resource objects.
-func (u *userBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+func (u *userBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
 	if parentResourceID == nil {
+		if u.groups.OrgTokenMode() {
+			return u.listOrgTokenUsers(ctx)
+		}
+
 		return nil, "", nil, nil
 	}
 
-	users, err := u.client.ListUsersInGroup(ctx)
+	if parentResourceID.ResourceType == tenantResourceType.Id {
+		return u.listTenantUsers(ctx, parentResourceID)
+	}
+
+	client, err := u.groups.ForParent(parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if err := u.principals.Load(ctx); err != nil {
+		return nil, "", nil, err
+	}
+	u.activity.Refresh(ctx)
+
+	// users are turned into resources as they are decoded, so the full response is never held in memory
+	var rv []*v2.Resource
+	err = client.ForEachUserInGroup(ctx, func(user *snyk.GroupUser) error {
+		// users that are members of several groups are only listed under the first one
+		if !u.principals.IsOwner(client.GroupID(), user.ID) {
+			return nil
+		}
+
+		excluded, labels := u.rules.Evaluate(user)
+		if excluded {
+			return nil
+		}
+
+		resource, err := userResource(ctx, user, labels, u.activity.Lookup(user.ID), parentResourceID)
+		if err != nil {
+			return fmt.Errorf("snyk-connector: failed to create user resource: %w", err)
+		}
+
+		rv = append(rv, resource)
+		return nil
+	})
 	if err != nil {
 		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list users: %w", err)
 	}
 
+	return rv, "", nil, nil
+}
+
+// listOrgTokenUsers returns members of all organizations accessible with the token as top level resources.
+func (u *userBuilder) listOrgTokenUsers(ctx context.Context) ([]*v2.Resource, string, annotations.Annotations, error) {
+	u.activity.Refresh(ctx)
+
+	var rv []*v2.Resource
+	err := forEachOrgTokenUser(ctx, u.groups.orgClient, u.filter, func(user *snyk.GroupUser) error {
+		excluded, labels := u.rules.Evaluate(user)
+		if excluded {
+			return nil
+		}
+
+		resource, err := userResource(ctx, user, labels, u.activity.Lookup(user.ID), nil)
+		if err != nil {
+			return fmt.Errorf("snyk-connector: failed to create user resource: %w", err)
+		}
+
+		rv = append(rv, resource)
+		return nil
+	})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list users: %w", err)
+	}
+
+	return rv, "", nil, nil
+}
+
+// listTenantUsers returns tenant members that aren't members of any synced group.
+func (u *userBuilder) listTenantUsers(ctx context.Context, parentResourceID *v2.ResourceId) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if err := u.principals.Load(ctx); err != nil {
+		return nil, "", nil, err
+	}
+	u.activity.Refresh(ctx)
+
 	var rv []*v2.Resource
-	for _, user := range users {
-		uCopy := user
-		resource, err := userResource(ctx, &uCopy, parentResourceID)
+	err := forEachTenantUser(ctx, u.tenant, func(user *snyk.GroupUser) error {
+		if !u.principals.IsOwner(parentResourceID.Resource, user.ID) {
+			return nil
+		}
+
+		excluded, labels := u.rules.Evaluate(user)
+		if excluded {
+			return nil
+		}
+
+		resource, err := userResource(ctx, user, labels, u.activity.Lookup(user.ID), parentResourceID)
 		if err != nil {
-			return nil, "", nil, fmt.Errorf("snyk-connector: failed to create user resource: %w", err)
+			return fmt.Errorf("snyk-connector: failed to create user resource: %w", err)
 		}
 
 		rv = append(rv, resource)
+		return nil
+	})
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("snyk-connector: failed to list users: %w", err)
 	}
 
 	return rv, "", nil, nil
@@ -77,8 +182,20 @@ func (u *userBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 	return nil, "", nil, nil
 }
 
-func newUserBuilder(client *snyk.Client) *userBuilder {
+func newUserBuilder(
+	groups *groupClients,
+	tenant *tenantScope,
+	filter *OrgFilter,
+	rules userRules,
+	principals *principalIndex,
+	activity *activityIndex,
+) *userBuilder {
 	return &userBuilder{
-		client: client,
+		groups:     groups,
+		tenant:     tenant,
+		filter:     filter,
+		rules:      rules,
+		principals: principals,
+		activity:   activity,
 	}
 }
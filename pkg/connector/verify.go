@@ -0,0 +1,132 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/conductorone/baton-snyk/pkg/snyk"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// membership changes usually show up within seconds, give up after about half a minute.
+	verifyMaxAttempts  = 7
+	verifyInitialDelay = 500 * time.Millisecond
+	verifyMaxDelay     = 8 * time.Second
+)
+
+// writeVerifier polls memberships after they are changed until Snyk reflects the change,
+// since membership updates are eventually consistent. A nil verifier doesn't verify anything.
+type writeVerifier struct {
+	attempts     int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+func newWriteVerifier() *writeVerifier {
+	return &writeVerifier{
+		attempts:     verifyMaxAttempts,
+		initialDelay: verifyInitialDelay,
+		maxDelay:     verifyMaxDelay,
+	}
+}
+
+// orgMember waits until the user is a member of the organization with the role, or isn't a member when role is nil.
+func (v *writeVerifier) orgMember(ctx context.Context, group *orgGroup, orgID, userID string, role *snyk.Role) error {
+	if v == nil {
+		return nil
+	}
+
+	expected := "no membership"
+	if role != nil {
+		expected = fmt.Sprintf("role %s", role.Slug)
+	}
+
+	return v.poll(ctx, fmt.Sprintf("user %s in org %s", userID, orgID), expected, func() (string, error) {
+		member, err := group.findMember(ctx, orgID, userID)
+		if err != nil {
+			return "", fmt.Errorf("snyk-connector: failed to verify membership of user %s in org %s: %w", userID, orgID, err)
+		}
+
+		if member == nil {
+			return "no membership", nil
+		}
+
+		return fmt.Sprintf("role %s", member.Role), nil
+	})
+}
+
+// tenantMember waits until the user is a member of the tenant with the role, or isn't a member when role is nil.
+func (v *writeVerifier) tenantMember(ctx context.Context, tenant *tenantScope, tenantID, userID string, role *snyk.TenantRole) error {
+	if v == nil {
+		return nil
+	}
+
+	expected := "no membership"
+	if role != nil {
+		expected = fmt.Sprintf("role %s", role.ID)
+	}
+
+	return v.poll(ctx, fmt.Sprintf("user %s in tenant %s", userID, tenantID), expected, func() (string, error) {
+		membership, _, err := tenant.findMembership(ctx, tenantID, userID)
+		if err != nil {
+			return "", fmt.Errorf("snyk-connector: failed to verify membership of user %s in tenant %s: %w", userID, tenantID, err)
+		}
+
+		if membership == nil {
+			return "no membership", nil
+		}
+
+		return fmt.Sprintf("role %s", membership.Role.ID), nil
+	})
+}
+
+// poll observes the membership with exponential backoff until it is in the expected state.
+func (v *writeVerifier) poll(ctx context.Context, membership, expected string, observe func() (string, error)) error {
+	l := ctxzap.Extract(ctx)
+
+	observed := ""
+	delay := v.initialDelay
+	for attempt := 1; attempt <= v.attempts; attempt++ {
+		var err error
+		observed, err = observe()
+		if err != nil {
+			return err
+		}
+
+		if observed == expected {
+			return nil
+		}
+
+		if attempt == v.attempts {
+			break
+		}
+
+		l.Debug(
+			"snyk-connector: membership change not observed yet",
+			zap.String("membership", membership),
+			zap.String("expected", expected),
+			zap.String("observed", observed),
+			zap.Int("attempt", attempt),
+			zap.Duration("retry_in", delay),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay = min(delay*2, v.maxDelay)
+	}
+
+	return status.Errorf(
+		codes.Aborted,
+		"snyk-connector: membership of %s didn't converge after %d checks: expected %s, observed %s",
+		membership, v.attempts, expected, observed,
+	)
+}
@@ -0,0 +1,86 @@
+package snyk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+)
+
+const auditLogPageSize = 100
+
+// AuditLogEvent is a single entry of the group or org audit log.
+// UserID is the user who made the change, the user it was made to is part of the content.
+type AuditLogEvent struct {
+	Created   time.Time      `json:"created"`
+	Event     string         `json:"event"`
+	GroupID   string         `json:"group_id"`
+	OrgID     string         `json:"org_id"`
+	ProjectID string         `json:"project_id"`
+	UserID    string         `json:"user_id"`
+	Content   map[string]any `json:"content"`
+}
+
+// AuditLogQuery selects audit log events created since From, listed from the oldest.
+type AuditLogQuery struct {
+	From time.Time
+	Size int
+}
+
+func (q *AuditLogQuery) Apply(params *url.Values) {
+	params.Set("version", RestVersion)
+	params.Set("sort_order", "ASC")
+	params.Set("from", q.From.UTC().Format(time.RFC3339))
+
+	size := q.Size
+	if size <= 0 || size > auditLogPageSize {
+		size = auditLogPageSize
+	}
+	params.Set("size", strconv.Itoa(size))
+}
+
+// SearchGroupAuditLogs returns a page of events from the audit log of the group, including events of its orgs.
+// With a next link returned by the previous call, the following page of the same search is returned instead.
+func (c *Client) SearchGroupAuditLogs(ctx context.Context, query *AuditLogQuery, next string) ([]AuditLogEvent, string, error) {
+	return c.searchAuditLogs(ctx, fmt.Sprintf(GroupAuditLogsEndpoint, c.groupID), query, next)
+}
+
+// SearchOrgAuditLogs returns a page of events from the audit log of the organization.
+// With a next link returned by the previous call, the following page of the same search is returned instead.
+func (c *Client) SearchOrgAuditLogs(ctx context.Context, orgID string, query *AuditLogQuery, next string) ([]AuditLogEvent, string, error) {
+	return c.searchAuditLogs(ctx, fmt.Sprintf(OrgAuditLogsEndpoint, orgID), query, next)
+}
+
+func (c *Client) searchAuditLogs(ctx context.Context, path string, query *AuditLogQuery, next string) ([]AuditLogEvent, string, error) {
+	urlAddress := c.prepareRestURL(path)
+	vars := []Vars{query}
+	if next != "" {
+		var err error
+		urlAddress, err = c.restNextURL(next)
+		if err != nil {
+			return nil, "", err
+		}
+
+		vars = nil
+	}
+
+	var res struct {
+		Data struct {
+			Items []AuditLogEvent `json:"items"`
+		} `json:"data"`
+		Links restLinks `json:"links"`
+	}
+	// polling the same search must return new events, so the response cache is bypassed
+	err := c.stream(ctx, urlAddress, vars, func(dec *json.Decoder) error {
+		return dec.Decode(&res)
+	}, uhttp.WithAcceptVndJSONHeader())
+	if err != nil {
+		return nil, "", err
+	}
+
+	return res.Data.Items, res.Links.Next, nil
+}
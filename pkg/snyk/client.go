@@ -2,6 +2,7 @@ package snyk
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -26,6 +27,18 @@ const (
 	OrgMembersEndpoint = "/members"
 
 	CurrentUserOrgsEndpoint = "/orgs"
+	CurrentUserEndpoint     = "/user/me"
+
+	// REST API is used for resources that aren't available in the v1 API.
+	RestPrefix  = "/rest"
+	RestVersion = "2024-10-15"
+
+	RestOrgMembershipsEndpoint = "/orgs/%s/memberships"
+	TenantEndpoint             = "/tenants/%s"
+	TenantMembershipsEndpoint  = "/memberships"
+	TenantRolesEndpoint        = "/roles"
+	GroupAuditLogsEndpoint     = "/groups/%s/audit_logs/search"
+	OrgAuditLogsEndpoint       = "/orgs/%s/audit_logs/search"
 
 	OrgAdminRole        = "admin"
 	OrgCollaboratorRole = "collaborator"
@@ -36,6 +49,7 @@ type Client struct {
 	baseUrl    *url.URL
 	token      string
 	groupID    string
+	dryRun     bool
 }
 
 func NewClient(ctx context.Context, groupID, token string) (*Client, error) {
@@ -59,11 +73,43 @@ func NewClient(ctx context.Context, groupID, token string) (*Client, error) {
 	}, nil
 }
 
+// ForGroup returns a client scoped to another group, sharing the underlying http client.
+func (c *Client) ForGroup(groupID string) *Client {
+	return &Client{
+		httpClient: c.httpClient,
+		baseUrl:    c.baseUrl,
+		token:      c.token,
+		groupID:    groupID,
+		dryRun:     c.dryRun,
+	}
+}
+
+// SetDryRun makes the client log mutating requests instead of sending them. Reads are still sent.
+// Clients created by ForGroup afterwards inherit the setting.
+func (c *Client) SetDryRun(dryRun bool) {
+	c.dryRun = dryRun
+}
+
+// SetBaseURL points the client at another API host, such as a regional one.
+// Clients created by ForGroup afterwards inherit the setting.
+func (c *Client) SetBaseURL(base *url.URL) {
+	c.baseUrl = base
+}
+
+// GroupID returns ID of the group the client is scoped to.
+func (c *Client) GroupID() string {
+	return c.groupID
+}
+
 func (c *Client) prepareURL(path string) *url.URL {
 	// Passing in the version separately since it encodes '/' if present in base url
 	return c.baseUrl.JoinPath(Version, path)
 }
 
+func (c *Client) prepareRestURL(path string) *url.URL {
+	return c.baseUrl.JoinPath(RestPrefix, path)
+}
+
 func (c *Client) ListUsersInOrg(ctx context.Context, orgID string) ([]OrgUser, error) {
 	path, err := url.JoinPath(fmt.Sprintf(OrgEndpoint, orgID), OrgMembersEndpoint)
 	if err != nil {
@@ -79,13 +125,15 @@ func (c *Client) ListUsersInOrg(ctx context.Context, orgID string) ([]OrgUser, e
 	return users, nil
 }
 
-func (c *Client) ListUsersInGroup(ctx context.Context) ([]GroupUser, error) {
-	path, err := url.JoinPath(fmt.Sprintf(GroupEndpoint, c.groupID), GroupMembersEndpoint)
+// ListExplicitUsersInOrg returns members of the organization without the admins of its group, who are only
+// listed as members of every organization of the group and can't be changed or removed through it.
+func (c *Client) ListExplicitUsersInOrg(ctx context.Context, orgID string) ([]OrgUser, error) {
+	path, err := url.JoinPath(fmt.Sprintf(OrgEndpoint, orgID), OrgMembersEndpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	var users []GroupUser
+	var users []OrgUser
 	_, err = c.get(ctx, c.prepareURL(path), &users, nil)
 	if err != nil {
 		return nil, err
@@ -94,6 +142,21 @@ func (c *Client) ListUsersInGroup(ctx context.Context) ([]GroupUser, error) {
 	return users, nil
 }
 
+// ListUsersInGroup returns all members of the group.
+// Prefer ForEachUserInGroup for large groups, since this holds every member in memory.
+func (c *Client) ListUsersInGroup(ctx context.Context) ([]GroupUser, error) {
+	var users []GroupUser
+	err := c.ForEachUserInGroup(ctx, func(user *GroupUser) error {
+		users = append(users, *user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 func (c *Client) GetGroupDetails(ctx context.Context) (*Group, error) {
 	path, err := url.JoinPath(fmt.Sprintf(GroupEndpoint, c.groupID), GroupOrgsEndpoint)
 	if err != nil {
@@ -173,7 +236,9 @@ type AddMemberBody struct {
 	Role   string `json:"role"`
 }
 
-func (c *Client) AddOrgMember(ctx context.Context, userID, orgID string) error {
+// AddOrgMember adds the user to the organization with the built-in role (admin or collaborator).
+// Members can be assigned custom roles with UpdateOrgRole afterwards.
+func (c *Client) AddOrgMember(ctx context.Context, userID, orgID, role string) error {
 	path, err := url.JoinPath(fmt.Sprintf(GroupEndpoint, c.groupID), fmt.Sprintf(OrgEndpoint, orgID), OrgMembersEndpoint)
 	if err != nil {
 		return err
@@ -181,7 +246,7 @@ func (c *Client) AddOrgMember(ctx context.Context, userID, orgID string) error {
 
 	body := &AddMemberBody{
 		UserId: userID,
-		Role:   OrgCollaboratorRole,
+		Role:   role,
 	}
 
 	_, err = c.post(ctx, c.prepareURL(path), body)
@@ -192,6 +257,55 @@ func (c *Client) AddOrgMember(ctx context.Context, userID, orgID string) error {
 	return nil
 }
 
+// AddOrgMembership adds the user to the organization with any role, including custom roles.
+// Unlike AddOrgMember, the member is created with the role right away, using the REST API.
+func (c *Client) AddOrgMembership(ctx context.Context, userID, orgID, roleID string) error {
+	body := &restMembershipBody{}
+	body.Data.Type = orgMembershipType
+	body.Data.Relationships = map[string]restRel{
+		"org":  {Data: restRelData{ID: orgID, Type: orgType}},
+		"user": {Data: restRelData{ID: userID, Type: userType}},
+		"role": {Data: restRelData{ID: roleID, Type: orgRoleType}},
+	}
+
+	return c.restRequest(ctx, c.prepareRestURL(fmt.Sprintf(RestOrgMembershipsEndpoint, orgID)), http.MethodPost, body, nil, []Vars{&restVersionVars{}})
+}
+
+// ListOrgMemberships returns memberships of all users in the organization along with the public ID of their role.
+// Admins of the group are only members of its organizations implicitly and aren't listed.
+func (c *Client) ListOrgMemberships(ctx context.Context, orgID string) ([]OrgMembership, error) {
+	var memberships []OrgMembership
+	err := restList(ctx, c, fmt.Sprintf(RestOrgMembershipsEndpoint, orgID), func(data []restData[struct{}]) error {
+		for _, d := range data {
+			user := d.Relationships["user"].Data
+			role := d.Relationships["role"].Data
+
+			membership := OrgMembership{
+				BaseResource: BaseResource{ID: d.ID},
+				User:         BaseUser{BaseResource: BaseResource{ID: user.ID}},
+				Role:         Role{ID: role.ID},
+			}
+			if user.Attributes != nil {
+				membership.User.Username = user.Attributes.Username
+				membership.User.Email = user.Attributes.Email
+				membership.User.Name = user.Attributes.Name
+			}
+			if role.Attributes != nil {
+				membership.Role.Name = role.Attributes.Name
+			}
+
+			memberships = append(memberships, membership)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
 func (c *Client) RemoveOrgMember(ctx context.Context, userID, orgID string) error {
 	path, err := url.JoinPath(fmt.Sprintf(OrgEndpoint, orgID), OrgMembersEndpoint, userID)
 	if err != nil {
@@ -256,6 +370,55 @@ func (c *Client) ListOrgs(ctx context.Context, pgVars *PaginationVars) ([]Org, s
 	return res.Orgs, link, nil
 }
 
+// ListCurrentUserOrgs returns all organizations the token has access to.
+// Unlike ListOrgs, it doesn't require access to the group.
+func (c *Client) ListCurrentUserOrgs(ctx context.Context) ([]Org, error) {
+	var res struct {
+		Orgs []Org `json:"orgs"`
+	}
+	_, err := c.get(ctx, c.prepareURL(CurrentUserOrgsEndpoint), &res, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Orgs, nil
+}
+
+// GetCurrentUser returns the user or service account the token represents.
+func (c *Client) GetCurrentUser(ctx context.Context) (*BaseUser, error) {
+	var user BaseUser
+	_, err := c.get(ctx, c.prepareURL(CurrentUserEndpoint), &user, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+type UpdateMemberBody struct {
+	Role string `json:"role"`
+}
+
+// UpdateOrgMemberRole sets the built-in role (admin or collaborator) of the organization member.
+// Unlike UpdateOrgRole, it doesn't require access to the group.
+func (c *Client) UpdateOrgMemberRole(ctx context.Context, userID, orgID, role string) error {
+	path, err := url.JoinPath(fmt.Sprintf(OrgEndpoint, orgID), OrgMembersEndpoint, userID)
+	if err != nil {
+		return err
+	}
+
+	body := &UpdateMemberBody{
+		Role: role,
+	}
+
+	_, err = c.put(ctx, c.prepareURL(path), body)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (c *Client) get(ctx context.Context, urlAddress *url.URL, response interface{}, vars []Vars) (string, error) {
 	return c.doRequest(ctx, urlAddress, http.MethodGet, nil, response, vars)
 }
@@ -272,7 +435,15 @@ func (c *Client) delete(ctx context.Context, urlAddress *url.URL) (string, error
 	return c.doRequest(ctx, urlAddress, http.MethodDelete, nil, nil, nil)
 }
 
-func (c *Client) doRequest(ctx context.Context, urlAddress *url.URL, method string, data interface{}, response interface{}, vars []Vars) (string, error) {
+func (c *Client) doRequest(
+	ctx context.Context,
+	urlAddress *url.URL,
+	method string,
+	data interface{},
+	response interface{},
+	vars []Vars,
+	extraOpts ...uhttp.RequestOption,
+) (string, error) {
 	if vars != nil {
 		query := url.Values{}
 
@@ -283,6 +454,10 @@ func (c *Client) doRequest(ctx context.Context, urlAddress *url.URL, method stri
 		urlAddress.RawQuery = query.Encode()
 	}
 
+	if c.dryRun && method != http.MethodGet {
+		return "", c.logDryRun(ctx, urlAddress, method, data)
+	}
+
 	opts := []uhttp.RequestOption{
 		uhttp.WithAcceptJSONHeader(),
 		uhttp.WithHeader("Authorization", fmt.Sprintf("token %s", c.token)),
@@ -292,6 +467,8 @@ func (c *Client) doRequest(ctx context.Context, urlAddress *url.URL, method stri
 		opts = append(opts, uhttp.WithJSONBody(data), uhttp.WithContentTypeJSONHeader())
 	}
 
+	opts = append(opts, extraOpts...)
+
 	req, err := c.httpClient.NewRequest(ctx, method, urlAddress, opts...)
 	if err != nil {
 		return "", err
@@ -314,3 +491,26 @@ func (c *Client) doRequest(ctx context.Context, urlAddress *url.URL, method stri
 
 	return resp.Header.Get("Link"), nil
 }
+
+// logDryRun logs the request that would be sent without the dry run.
+func (c *Client) logDryRun(ctx context.Context, urlAddress *url.URL, method string, data interface{}) error {
+	body := ""
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		body = string(b)
+	}
+
+	l := ctxzap.Extract(ctx)
+	l.Info(
+		"snyk-connector: dry run, skipping request",
+		zap.String("method", method),
+		zap.String("path", urlAddress.RequestURI()),
+		zap.String("body", body),
+	)
+
+	return nil
+}
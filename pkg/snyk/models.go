@@ -20,19 +20,33 @@ type OrgUser struct {
 
 type GroupUser struct {
 	BaseUser
-	Role string `json:"groupRole"`
-	Orgs []struct {
-		Name string `json:"name"`
-		Role string `json:"role"`
-	} `json:"orgs"`
+	Role string         `json:"groupRole"`
+	Orgs []GroupUserOrg `json:"orgs"`
+}
+
+// GroupUserOrg is an organization membership listed in the group members response.
+// Snyk doesn't always return the organization ID here, only its name.
+type GroupUserOrg struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// OrgMembership is a membership of the user in the organization listed by the REST API. Unlike OrgUser,
+// it carries the public ID of the role, so custom roles sharing a slug can be told apart.
+type OrgMembership struct {
+	BaseResource
+	User BaseUser
+	Role Role
 }
 
 type Org struct {
 	BaseResource
-	Name  string `json:"name"`
-	Slug  string `json:"slug"`
-	URL   string `json:"url"`
-	Group *Group `json:"group"`
+	Name    string `json:"name"`
+	Slug    string `json:"slug"`
+	URL     string `json:"url"`
+	Created string `json:"created"`
+	Group   *Group `json:"group"`
 }
 
 type Group struct {
@@ -54,8 +68,41 @@ type Role struct {
 type ErrorResp struct {
 	Err string `json:"error"`
 	Msg string `json:"message"`
+
+	// Errors are returned by the REST API instead of the error and message.
+	Errors []RestError `json:"errors"`
+}
+
+type RestError struct {
+	Status string `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
 }
 
 func (e *ErrorResp) Message() string {
+	if len(e.Errors) > 0 {
+		return fmt.Sprintf("unexpected error from snyk api: %s, %v", e.Errors[0].Title, e.Errors[0].Detail)
+	}
+
 	return fmt.Sprintf("unexpected error from snyk api: %s, %v", e.Err, e.Msg)
 }
+
+type Tenant struct {
+	BaseResource
+	Name    string
+	Slug    string
+	Created string
+}
+
+type TenantRole struct {
+	BaseResource
+	Name        string
+	Description string
+}
+
+// TenantMembership is a membership of the user in the tenant, carrying the tenant role of the user.
+type TenantMembership struct {
+	BaseResource
+	User BaseUser
+	Role TenantRole
+}
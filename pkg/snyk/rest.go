@@ -0,0 +1,148 @@
+package snyk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+)
+
+const (
+	restPageLimit = "100"
+
+	userType          = "user"
+	orgType           = "org"
+	orgMembershipType = "org_membership"
+	orgRoleType       = "org_role"
+)
+
+// restData is a single resource object of the REST (JSON:API) responses.
+type restData[T any] struct {
+	ID            string             `json:"id"`
+	Type          string             `json:"type"`
+	Attributes    T                  `json:"attributes"`
+	Relationships map[string]restRel `json:"relationships,omitempty"`
+}
+
+type restRel struct {
+	Data restRelData `json:"data"`
+}
+
+type restRelData struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Attributes *struct {
+		Name        string `json:"name"`
+		Email       string `json:"email"`
+		Username    string `json:"username"`
+		Description string `json:"description"`
+	} `json:"attributes,omitempty"`
+}
+
+// restMembershipBody is the request body creating or updating a tenant or org membership.
+type restMembershipBody struct {
+	Data struct {
+		ID            string             `json:"id,omitempty"`
+		Type          string             `json:"type"`
+		Relationships map[string]restRel `json:"relationships"`
+	} `json:"data"`
+}
+
+type restLinks struct {
+	Next string `json:"next"`
+}
+
+type restVersionVars struct {
+	limit bool
+}
+
+func (v *restVersionVars) Apply(params *url.Values) {
+	params.Set("version", RestVersion)
+	if v.limit {
+		params.Set("limit", restPageLimit)
+	}
+}
+
+func (c *Client) restRequest(ctx context.Context, urlAddress *url.URL, method string, data interface{}, response interface{}, vars []Vars) error {
+	opts := []uhttp.RequestOption{
+		uhttp.WithAcceptVndJSONHeader(),
+	}
+	if data != nil {
+		opts = append(opts, uhttp.WithContentTypeVndHeader())
+	}
+
+	_, err := c.doRequest(ctx, urlAddress, method, data, response, vars, opts...)
+	return err
+}
+
+// restList calls fn for every page of the REST list endpoint, following the next links.
+func restList[T any](ctx context.Context, c *Client, path string, fn func(data []restData[T]) error) error {
+	return restPages(ctx, c, path, func(urlAddress *url.URL, vars []Vars, res interface{}) error {
+		return c.restRequest(ctx, urlAddress, http.MethodGet, nil, res, vars)
+	}, fn)
+}
+
+// restListDirect is restList reading every page directly from the API, bypassing the response cache,
+// for lookups that must observe changes made since the previous request.
+func restListDirect[T any](ctx context.Context, c *Client, path string, fn func(data []restData[T]) error) error {
+	return restPages(ctx, c, path, func(urlAddress *url.URL, vars []Vars, res interface{}) error {
+		return c.stream(ctx, urlAddress, vars, func(dec *json.Decoder) error {
+			return dec.Decode(res)
+		}, uhttp.WithAcceptVndJSONHeader())
+	}, fn)
+}
+
+func restPages[T any](
+	ctx context.Context,
+	c *Client,
+	path string,
+	get func(urlAddress *url.URL, vars []Vars, res interface{}) error,
+	fn func(data []restData[T]) error,
+) error {
+	urlAddress := c.prepareRestURL(path)
+	vars := []Vars{&restVersionVars{limit: true}}
+
+	for {
+		var res struct {
+			Data  []restData[T] `json:"data"`
+			Links restLinks     `json:"links"`
+		}
+		if err := get(urlAddress, vars, &res); err != nil {
+			return err
+		}
+
+		if err := fn(res.Data); err != nil {
+			return err
+		}
+
+		if res.Links.Next == "" {
+			return nil
+		}
+
+		next, err := c.restNextURL(res.Links.Next)
+		if err != nil {
+			return err
+		}
+
+		urlAddress = next
+		vars = nil
+	}
+}
+
+// restNextURL resolves the next page link of a REST response. The link carries all query parameters.
+func (c *Client) restNextURL(link string) (*url.URL, error) {
+	next, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse next page link '%s': %w", link, err)
+	}
+
+	// next links are relative and may or may not include the REST prefix
+	urlAddress := c.prepareRestURL(strings.TrimPrefix(next.Path, RestPrefix))
+	urlAddress.RawQuery = next.RawQuery
+
+	return urlAddress, nil
+}
@@ -0,0 +1,144 @@
+package snyk
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/conductorone/baton-sdk/pkg/uhttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ForEachUserInGroup calls fn for every member of the group as it is decoded from the response.
+// Unlike ListUsersInGroup, members are never held in memory all at once.
+func (c *Client) ForEachUserInGroup(ctx context.Context, fn func(user *GroupUser) error) error {
+	path, err := url.JoinPath(fmt.Sprintf(GroupEndpoint, c.groupID), GroupMembersEndpoint)
+	if err != nil {
+		return err
+	}
+
+	return c.stream(ctx, c.prepareURL(path), nil, func(dec *json.Decoder) error {
+		return decodeArray(dec, func(dec *json.Decoder) error {
+			var user GroupUser
+			if err := dec.Decode(&user); err != nil {
+				return err
+			}
+
+			return fn(&user)
+		})
+	})
+}
+
+// ForEachUserInOrg calls fn for every member of the organization as it is decoded from the response.
+// Members are read directly from the API, bypassing the response cache.
+func (c *Client) ForEachUserInOrg(ctx context.Context, orgID string, fn func(user *OrgUser) error) error {
+	path, err := url.JoinPath(fmt.Sprintf(OrgEndpoint, orgID), OrgMembersEndpoint)
+	if err != nil {
+		return err
+	}
+
+	return c.stream(ctx, c.prepareURL(path), []Vars{WithIncludeAdminVar()}, func(dec *json.Decoder) error {
+		return decodeArray(dec, func(dec *json.Decoder) error {
+			var user OrgUser
+			if err := dec.Decode(&user); err != nil {
+				return err
+			}
+
+			return fn(&user)
+		})
+	})
+}
+
+// decodeArray walks the top level JSON array token by token and calls fn for each element.
+func decodeArray(dec *json.Decoder, fn func(dec *json.Decoder) error) error {
+	t, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read json response: %w", err)
+	}
+
+	if d, ok := t.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("unexpected json response: expected array, got %v", t)
+	}
+
+	for dec.More() {
+		if err := fn(dec); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("failed to read json response: %w", err)
+	}
+
+	return nil
+}
+
+// stream issues a GET request and passes the decoder over the response body to fn.
+// The request is sent directly through the http client, so the body is neither buffered nor cached.
+func (c *Client) stream(ctx context.Context, urlAddress *url.URL, vars []Vars, fn func(dec *json.Decoder) error, extraOpts ...uhttp.RequestOption) error {
+	if vars != nil {
+		query := url.Values{}
+
+		for _, v := range vars {
+			v.Apply(&query)
+		}
+
+		urlAddress.RawQuery = query.Encode()
+	}
+
+	opts := []uhttp.RequestOption{
+		uhttp.WithAcceptJSONHeader(),
+		uhttp.WithHeader("Authorization", fmt.Sprintf("token %s", c.token)),
+	}
+	opts = append(opts, extraOpts...)
+
+	req, err := c.httpClient.NewRequest(ctx, http.MethodGet, urlAddress, opts...)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+
+	return fn(json.NewDecoder(resp.Body))
+}
+
+// responseError maps unsuccessful response to an error the same way as the uhttp client does.
+func responseError(resp *http.Response) error {
+	var code codes.Code
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout:
+		code = codes.DeadlineExceeded
+	case http.StatusTooManyRequests:
+		code = codes.Unavailable
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	default:
+		code = codes.Unknown
+	}
+
+	errResp := &ErrorResp{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(errResp); err != nil {
+		return status.Error(code, resp.Status)
+	}
+
+	return status.Error(code, fmt.Sprintf("Request failed with status %d: %s", resp.StatusCode, errResp.Message()))
+}
@@ -0,0 +1,92 @@
+package snyk
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeArray(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty array", body: `[]`, want: nil},
+		{name: "empty array with whitespace", body: " [ \n ] ", want: nil},
+		{name: "single element", body: `[{"id":"a"}]`, want: []string{"a"}},
+		{name: "several elements", body: `[{"id":"a"},{"id":"b"},{"id":"c"}]`, want: []string{"a", "b", "c"}},
+		{name: "unknown fields are ignored", body: `[{"id":"a","orgs":[{"name":"x"}]}]`, want: []string{"a"}},
+		{name: "empty body", body: ``, wantErr: true},
+		{name: "object instead of array", body: `{"id":"a"}`, wantErr: true},
+		{name: "null", body: `null`, wantErr: true},
+		{name: "truncated before the first element", body: `[`, wantErr: true},
+		{name: "truncated inside an element", body: `[{"id":"a"},{"id":`, wantErr: true},
+		{name: "truncated before the closing bracket", body: `[{"id":"a"},{"id":"b"}`, wantErr: true},
+		{name: "invalid element", body: `[{"id":"a"},42]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := decodeArray(json.NewDecoder(strings.NewReader(tt.body)), func(dec *json.Decoder) error {
+				var v struct {
+					ID string `json:"id"`
+				}
+				if err := dec.Decode(&v); err != nil {
+					return err
+				}
+
+				got = append(got, v.ID)
+				return nil
+			})
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decodeArray(%q) succeeded with %v, want an error", tt.body, got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("decodeArray(%q) failed: %v", tt.body, err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("decodeArray(%q) decoded %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeArrayStopsOnCallbackError(t *testing.T) {
+	stop := errors.New("stop")
+
+	calls := 0
+	err := decodeArray(json.NewDecoder(strings.NewReader(`[1,2,3]`)), func(dec *json.Decoder) error {
+		calls++
+
+		var v int
+		if err := dec.Decode(&v); err != nil {
+			return err
+		}
+
+		if v == 2 {
+			return stop
+		}
+
+		return nil
+	})
+
+	if !errors.Is(err, stop) {
+		t.Fatalf("decodeArray returned %v, want the callback error", err)
+	}
+
+	if calls != 2 {
+		t.Errorf("decodeArray called the callback %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,177 @@
+package snyk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	tenantMembershipType = "tenant_membership"
+	tenantRoleType       = "tenant_role"
+)
+
+type tenantAttributes struct {
+	Name      string `json:"name"`
+	Slug      string `json:"slug"`
+	CreatedAt string `json:"created_at"`
+}
+
+type tenantRoleAttributes struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// GetTenant returns details of the tenant.
+func (c *Client) GetTenant(ctx context.Context, tenantID string) (*Tenant, error) {
+	var res struct {
+		Data restData[tenantAttributes] `json:"data"`
+	}
+	err := c.restRequest(ctx, c.prepareRestURL(fmt.Sprintf(TenantEndpoint, tenantID)), http.MethodGet, nil, &res, []Vars{&restVersionVars{}})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tenant{
+		BaseResource: BaseResource{ID: res.Data.ID},
+		Name:         res.Data.Attributes.Name,
+		Slug:         res.Data.Attributes.Slug,
+		Created:      res.Data.Attributes.CreatedAt,
+	}, nil
+}
+
+// ListTenantRoles returns all roles that can be assigned to members of the tenant.
+func (c *Client) ListTenantRoles(ctx context.Context, tenantID string) ([]TenantRole, error) {
+	path, err := url.JoinPath(fmt.Sprintf(TenantEndpoint, tenantID), TenantRolesEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []TenantRole
+	err = restList(ctx, c, path, func(data []restData[tenantRoleAttributes]) error {
+		for _, d := range data {
+			roles = append(roles, TenantRole{
+				BaseResource: BaseResource{ID: d.ID},
+				Name:         d.Attributes.Name,
+				Description:  d.Attributes.Description,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// ListTenantMemberships returns memberships of all users in the tenant.
+func (c *Client) ListTenantMemberships(ctx context.Context, tenantID string) ([]TenantMembership, error) {
+	path, err := url.JoinPath(fmt.Sprintf(TenantEndpoint, tenantID), TenantMembershipsEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var memberships []TenantMembership
+	err = restList(ctx, c, path, func(data []restData[struct{}]) error {
+		memberships = appendTenantMemberships(memberships, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+// LookupTenantMemberships returns memberships of all users in the tenant.
+// Memberships are read directly from the API, bypassing the response cache.
+func (c *Client) LookupTenantMemberships(ctx context.Context, tenantID string) ([]TenantMembership, error) {
+	path, err := url.JoinPath(fmt.Sprintf(TenantEndpoint, tenantID), TenantMembershipsEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var memberships []TenantMembership
+	err = restListDirect(ctx, c, path, func(data []restData[struct{}]) error {
+		memberships = appendTenantMemberships(memberships, data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+func appendTenantMemberships(memberships []TenantMembership, data []restData[struct{}]) []TenantMembership {
+	for _, d := range data {
+		user := d.Relationships["user"].Data
+		role := d.Relationships["role"].Data
+
+		membership := TenantMembership{
+			BaseResource: BaseResource{ID: d.ID},
+			User:         BaseUser{BaseResource: BaseResource{ID: user.ID}},
+			Role:         TenantRole{BaseResource: BaseResource{ID: role.ID}},
+		}
+		if user.Attributes != nil {
+			membership.User.Username = user.Attributes.Username
+			membership.User.Email = user.Attributes.Email
+			membership.User.Name = user.Attributes.Name
+		}
+		if role.Attributes != nil {
+			membership.Role.Name = role.Attributes.Name
+			membership.Role.Description = role.Attributes.Description
+		}
+
+		memberships = append(memberships, membership)
+	}
+
+	return memberships
+}
+
+// AddTenantMember creates membership of the user in the tenant with the role.
+func (c *Client) AddTenantMember(ctx context.Context, tenantID, userID, roleID string) error {
+	path, err := url.JoinPath(fmt.Sprintf(TenantEndpoint, tenantID), TenantMembershipsEndpoint)
+	if err != nil {
+		return err
+	}
+
+	body := &restMembershipBody{}
+	body.Data.Type = tenantMembershipType
+	body.Data.Relationships = map[string]restRel{
+		"user": {Data: restRelData{ID: userID, Type: userType}},
+		"role": {Data: restRelData{ID: roleID, Type: tenantRoleType}},
+	}
+
+	return c.restRequest(ctx, c.prepareRestURL(path), http.MethodPost, body, nil, []Vars{&restVersionVars{}})
+}
+
+// UpdateTenantMembership changes the role of the tenant membership.
+func (c *Client) UpdateTenantMembership(ctx context.Context, tenantID, membershipID, roleID string) error {
+	path, err := url.JoinPath(fmt.Sprintf(TenantEndpoint, tenantID), TenantMembershipsEndpoint, membershipID)
+	if err != nil {
+		return err
+	}
+
+	body := &restMembershipBody{}
+	body.Data.ID = membershipID
+	body.Data.Type = tenantMembershipType
+	body.Data.Relationships = map[string]restRel{
+		"role": {Data: restRelData{ID: roleID, Type: tenantRoleType}},
+	}
+
+	return c.restRequest(ctx, c.prepareRestURL(path), http.MethodPatch, body, nil, []Vars{&restVersionVars{}})
+}
+
+// RemoveTenantMembership removes the user from the tenant.
+func (c *Client) RemoveTenantMembership(ctx context.Context, tenantID, membershipID string) error {
+	path, err := url.JoinPath(fmt.Sprintf(TenantEndpoint, tenantID), TenantMembershipsEndpoint, membershipID)
+	if err != nil {
+		return err
+	}
+
+	return c.restRequest(ctx, c.prepareRestURL(path), http.MethodDelete, nil, nil, []Vars{&restVersionVars{}})
+}